@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
 	"github.com/couchbaselabs/go-couchbase"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -25,21 +27,34 @@ const (
 	createBucketUri      = "/pools/default/buckets"
 	flushBucketUri       = "/controller/doFlush"
 	remoteClusterUri     = "/pools/default/remoteClusters"
+	tasksUri             = "/pools/default/tasks"
+	cancelXDCRUri        = "/controller/cancelXDCR"
+	setupServicesUri     = "/node/controller/setupServices"
+
+	remoteClusterName = "remote"
 )
 
 type CouchbaseNode struct {
-	Ip              string `json:"ip"`
-	Port            string `json:"port"`
-	BaseURL         string
-	AdminUserName   string `json:"username"`
-	AdminPassword   string `json:"password"`
-	SSHUserName     string `json:"ssh-username"`
-	SSHPassword     string `json:"ssh-password"`
-	HttpClient      *http.Client
-	Bucket          *couchbase.Bucket
-	WorkloadCommand chan int
-	KnownNodes      map[string]*CouchbaseNode
-	EjectNodes      map[string]*CouchbaseNode
+	Ip                 string `json:"ip"`
+	Port               string `json:"port"`
+	BaseURL            string
+	AdminUserName      string   `json:"username"`
+	AdminPassword      string   `json:"password"`
+	SSHUserName        string   `json:"ssh-username"`
+	SSHPassword        string   `json:"ssh-password"`
+	SSHPort            string   `json:"ssh-port"`
+	SSHKeyFile         string   `json:"ssh-key-file"`
+	Roles              []string `json:"roles"`
+	Services           []string `json:"services"`
+	TLS                bool     `json:"tls"`
+	TLSPort            string   `json:"tls-port"`
+	CAFile             string   `json:"ca-file"`
+	ClientCertFile     string   `json:"client-cert-file"`
+	ClientKeyFile      string   `json:"client-key-file"`
+	InsecureSkipVerify bool     `json:"insecure-skip-verify"`
+	HttpClient         *http.Client
+	Bucket             *couchbase.Bucket
+	WorkloadCommand    chan int
 }
 
 type RebalanceStatus struct {
@@ -47,13 +62,38 @@ type RebalanceStatus struct {
 }
 
 func (node *CouchbaseNode) StartService() (err error) {
+	command := "/etc/init.d/couchbase-server start"
+	return node.runSSH(command, command)
+}
+
+// runSSH runs command on the node, authenticating with the key file when
+// one is configured and with the password otherwise. Failures are logged
+// with description, which must not carry any credentials of the command.
+func (node *CouchbaseNode) runSSH(command string, description string) (err error) {
+	var auth ssh.AuthMethod
+	if node.SSHKeyFile != "" {
+		key, err := ioutil.ReadFile(node.SSHKeyFile)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to read ssh key %s %v", node.SSHKeyFile, err))
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to parse ssh key %s %v", node.SSHKeyFile, err))
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		auth = ssh.Password(node.SSHPassword)
+	}
 	config := &ssh.ClientConfig{
 		User: node.SSHUserName,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(node.SSHPassword),
-		},
+		Auth: []ssh.AuthMethod{auth},
 	}
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", node.Ip), config)
+
+	port := node.SSHPort
+	if port == "" {
+		port = "22"
+	}
+	client, err := ssh.Dial("tcp", joinHostPort(node.Ip, port), config)
 	if err != nil {
 		return err
 	}
@@ -65,46 +105,58 @@ func (node *CouchbaseNode) StartService() (err error) {
 	}
 	defer session.Close()
 
-	command := "/etc/init.d/couchbase-server start"
 	if err := session.Run(command); err != nil {
-		fmt.Printf("Failed to run command %s", command)
+		logger.Printf(logger.ERR, "Failed to run command %s on %s", description, node.Ip)
 		return err
 	}
 	return nil
 }
 
 func (node *CouchbaseNode) Init() (err error) {
-	userinfo := url.UserPassword(node.AdminUserName, node.AdminPassword)
+	if err = node.Connect(); err != nil {
+		return err
+	}
+	if err = node.InitializeSetting(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Connect sets up the REST client without touching the cluster settings,
+// for commands that work against an already initialized cluster. With tls
+// the calls go to the https port.
+func (node *CouchbaseNode) Connect() (err error) {
 	u := &url.URL{
-		Scheme: "http",
-		User:   userinfo,
-		Host:   node.Ip + ":" + node.Port,
+		Scheme: node.scheme(),
+		Host:   node.managementHost(),
 	}
 
-	node.HttpClient = &http.Client{}
+	if node.HttpClient, err = node.newHttpClient(); err != nil {
+		return errors.New(fmt.Sprintf("Unable to set up the client of couchbase node %s %v", node.Ip, err))
+	}
+	node.setupKVTLS()
 	node.BaseURL = u.String()
-	node.KnownNodes = make(map[string]*CouchbaseNode)
-	node.EjectNodes = make(map[string]*CouchbaseNode)
-	node.KnownNodes[node.Ip] = node
-    if err = node.InitializeSetting(); err != nil {
-        return err
-    }
 	return nil
 }
 
 func (node *CouchbaseNode) AddNode(n *CouchbaseNode) (err error) {
 	values := url.Values{}
-	values.Set("hostname", n.Ip)
+	values.Set("hostname", joinHostPort(n.Ip, n.Port))
+	if n.TLS {
+		//Add the node over https so its credentials are not sent in the clear
+		values.Set("hostname", fmt.Sprintf("https://%s", n.managementHost()))
+	}
 	values.Set("user", n.AdminUserName)
 	values.Set("password", n.AdminPassword)
+	if len(n.Services) > 0 {
+		values.Set("services", strings.Join(n.Services, ","))
+	}
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, addNodeUri)
-	delete(node.KnownNodes, n.Ip)
 
-	node.HttpClient = &http.Client{}
 	resp, err := node.HttpClient.PostForm(api, values)
 	if err != nil {
-		fmt.Printf("error getting response %v", err)
+		logger.Printf(logger.ERR, "error getting response %v", err)
 		return err
 	}
 
@@ -112,61 +164,83 @@ func (node *CouchbaseNode) AddNode(n *CouchbaseNode) (err error) {
 
 	if resp.StatusCode != http.StatusOK {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n body of the response with error %s %s", body, n.Ip)
+			logger.Printf(logger.ERR, "body of the response with error %s %s", body, n.Ip)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
-	node.KnownNodes[n.Ip] = n
 	return nil
 }
 
+// SetupServices sets the services of a node that is not part of a cluster
+// yet. Nodes that are added get their services from AddNode.
+func (node *CouchbaseNode) SetupServices() (err error) {
+	values := url.Values{}
+	values.Set("services", strings.Join(node.Services, ","))
+
+	api := fmt.Sprintf("%s%s", node.BaseURL, setupServicesUri)
+	resp, err := node.HttpClient.PostForm(api, values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Received a bad status %v %s", resp.Status, body))
+	}
+	return nil
+}
+
+// EjectNode removes a node that was added or failed over but not
+// rebalanced yet
 func (node *CouchbaseNode) EjectNode(n *CouchbaseNode) (err error) {
+	member, err := node.ClusterNode(n)
+	if err != nil {
+		return err
+	}
 	values := url.Values{}
-	values.Set("otpNode", fmt.Sprintf("ns_1@%s", n.Ip))
+	values.Set("otpNode", member.OtpNode)
 	api := fmt.Sprintf("%s%s", node.BaseURL, ejectNodeUri)
 
-	node.EjectNodes[n.Ip] = n
-	node.KnownNodes[n.Ip] = n
-
 	resp, err := node.HttpClient.PostForm(api, values)
 	if err != nil {
-		fmt.Printf("Error getting a response")
+		logger.Printf(logger.ERR, "Error getting a response")
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n body of the response with error %s", body)
+			logger.Printf(logger.ERR, "body of the response with error %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
-	node.EjectNodes[n.Ip] = n
-    fmt.Printf("\n Known nodes %v", node.KnownNodes)
-	delete(node.KnownNodes, n.Ip)
-
 	return nil
 }
 
 func (node *CouchbaseNode) FailoverNode(n *CouchbaseNode) (err error) {
+	member, err := node.ClusterNode(n)
+	if err != nil {
+		return err
+	}
 	values := url.Values{}
-	values.Set("otpNode", fmt.Sprintf("ns_1@%s", n.Ip))
+	values.Set("otpNode", member.OtpNode)
 	api := fmt.Sprintf("%s%s", node.BaseURL, failoverNodeUri)
-	fmt.Printf("failover api %s %s", api,n.Ip)
+	logger.Printf(logger.DEBUG, "failover api %s %s", api, n.Ip)
 
-    req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
-    req.Header.Set("Content-Type","application/x-www-form-urlencoded")
+	req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp, err := node.HttpClient.Do(req)
 
 	if err != nil {
-		fmt.Printf("Error getting a response")
+		logger.Printf(logger.ERR, "Error getting a response")
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n FailoverNode: body of the response with error %s", body)
+			logger.Printf(logger.ERR, "FailoverNode: body of the response with error %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
@@ -174,36 +248,34 @@ func (node *CouchbaseNode) FailoverNode(n *CouchbaseNode) (err error) {
 	return nil
 }
 
-func (node *CouchbaseNode) StartRebalance() (err error) {
-	var ejectedNodes, knownNodes string
-
-	values := url.Values{}
-
-	for ip, _ := range node.EjectNodes {
-		if len(ejectedNodes) == 0 {
-			ejectedNodes = fmt.Sprintf("ns_1@%s", ip)
-		} else {
-			ejectedNodes = fmt.Sprintf("%s,ns_1@%s", ejectedNodes, ip)
-		}
+// StartRebalance rebalances the cluster of node, ejecting the nodes in
+// eject and the ones that were failed over. The members are read from the
+// cluster, so nodes added or removed elsewhere are taken into account.
+func (node *CouchbaseNode) StartRebalance(eject []*CouchbaseNode) (err error) {
+	members, err := node.ClusterNodes()
+	if err != nil {
+		return err
 	}
-
-	values.Set("ejectedNodes", ejectedNodes)
-
-	for ip, _ := range node.KnownNodes {
-		if len(knownNodes) == 0 {
-			knownNodes = fmt.Sprintf("ns_1@%s", ip)
-		} else {
-			knownNodes = fmt.Sprintf("%s,ns_1@%s", knownNodes, ip)
+	knownNodes, ejectedNodes, err := rebalanceNodes(members, eject)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Status != NodeHealthy {
+			logger.Printf(logger.INFO, "Node %s is %s before the rebalance", member.OtpNode, member.Status)
 		}
 	}
-	values.Set("knownNodes", knownNodes)
+
+	values := url.Values{}
+	values.Set("knownNodes", strings.Join(knownNodes, ","))
+	values.Set("ejectedNodes", strings.Join(ejectedNodes, ","))
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, startRebalanceUri)
-	fmt.Printf("\n %v", values)
+	logger.Printf(logger.DEBUG, "%v", values)
 
 	resp, err := node.HttpClient.PostForm(api, values)
 	if err != nil {
-		fmt.Printf("error getting response %v", err)
+		logger.Printf(logger.ERR, "error getting response %v", err)
 		return err
 	}
 
@@ -211,7 +283,7 @@ func (node *CouchbaseNode) StartRebalance() (err error) {
 
 	if resp.StatusCode != http.StatusOK {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n body of the response with error %s", body)
+			logger.Printf(logger.ERR, "body of the response with error %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
@@ -236,7 +308,7 @@ func (node *CouchbaseNode) RebalanceProgress() (status string, err error) {
 	if body, err := ioutil.ReadAll(resp.Body); err == nil {
 		err = json.Unmarshal(body, &resJson)
 		if err != nil {
-			fmt.Printf("\n error nmarshaling %v", err)
+			logger.Printf(logger.ERR, "error nmarshaling %v", err)
 			return status, err
 		}
 	}
@@ -253,17 +325,16 @@ func (node *CouchbaseNode) InitializeSetting() (err error) {
 	values.Set("port", node.Port)
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, settingsUri)
-	node.HttpClient = &http.Client{}
 
 	req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
 	if err != nil {
-		fmt.Printf("Error creating request %v", req)
+		logger.Printf(logger.ERR, "Error creating request %v", req)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp, err := node.HttpClient.Do(req)
 
 	if err != nil {
-		fmt.Printf("error getting initialize bucket response %v", err)
+		logger.Printf(logger.ERR, "error getting initialize bucket response %v", err)
 		return err
 	}
 
@@ -271,7 +342,7 @@ func (node *CouchbaseNode) InitializeSetting() (err error) {
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n error reading create bucket response %s", body)
+			logger.Printf(logger.ERR, "error reading create bucket response %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
@@ -290,17 +361,16 @@ func (node *CouchbaseNode) CreateBucket(bucketname string) (err error) {
 	values.Set("proxyPort", "11220")
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, createBucketUri)
-	node.HttpClient = &http.Client{}
 
 	req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
 	if err != nil {
-		fmt.Printf("Error creating request %v", req)
+		logger.Printf(logger.ERR, "Error creating request %v", req)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp, err := node.HttpClient.Do(req)
 
 	if err != nil {
-		fmt.Printf("error getting create bucket response %v", err)
+		logger.Printf(logger.ERR, "error getting create bucket response %v", err)
 		return err
 	}
 
@@ -308,7 +378,7 @@ func (node *CouchbaseNode) CreateBucket(bucketname string) (err error) {
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n error reading create bucket response %s", body)
+			logger.Printf(logger.ERR, "error reading create bucket response %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
@@ -318,8 +388,8 @@ func (node *CouchbaseNode) CreateBucket(bucketname string) (err error) {
 
 func (node *CouchbaseNode) ConnectToBucket(bucketname string) (err error) {
 	u := &url.URL{
-		Scheme: "http",
-		Host:   node.Ip + ":" + node.Port,
+		Scheme: node.scheme(),
+		Host:   node.managementHost(),
 	}
 
 	c, err := couchbase.Connect(u.String())
@@ -339,35 +409,89 @@ func (node *CouchbaseNode) DoOp(opName string, key string, doc interface{}) (err
 	var dummy interface{}
 	switch {
 	case opName == "GET":
-		err = node.Bucket.Get(key, dummy)
+		err = node.Bucket.Get(key, &dummy)
 	case opName == "SET":
 		err = node.Bucket.Set(key, 0, doc)
+	case opName == "DELETE":
+		err = node.Bucket.Delete(key)
 	}
 	if err != nil {
-		fmt.Printf("Error while doing an operation %v", err)
+		logger.Printf(logger.ERR, "Error while doing an operation %v", err)
+	}
+	return err
+}
+
+// SetWithExpiry stores doc under key to expire after expiry seconds
+func (node *CouchbaseNode) SetWithExpiry(key string, expiry int, doc interface{}) (err error) {
+	if err = node.Bucket.Set(key, expiry, doc); err != nil {
+		logger.Printf(logger.ERR, "Error while doing an operation %v", err)
 	}
 	return err
 }
 
+// SetRaw stores value under key as is, without encoding it as JSON
+func (node *CouchbaseNode) SetRaw(key string, value []byte) (err error) {
+	return node.Bucket.SetRaw(key, 0, value)
+}
+
+// AppendRaw appends value to the stored value of key
+func (node *CouchbaseNode) AppendRaw(key string, value []byte) (err error) {
+	return node.Bucket.Append(key, value)
+}
+
+// PrependRaw puts value in front of the stored value of key
+func (node *CouchbaseNode) PrependRaw(key string, value []byte) (err error) {
+	current, err := node.Bucket.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	return node.Bucket.SetRaw(key, 0, append(append([]byte{}, value...), current...))
+}
+
+// CompactBucket starts a compaction of bucketname, which purges expired
+// documents
+func (node *CouchbaseNode) CompactBucket(bucketname string) (err error) {
+	api := fmt.Sprintf("%s%s/%s/controller/compactBucket", node.BaseURL, createBucketUri, bucketname)
+	resp, err := node.HttpClient.PostForm(api, url.Values{})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Received a bad status %v %s", resp.Status, body))
+	}
+	return nil
+}
+
+// SetExpiryPager makes the expiry pager of bucketname on the node run
+// every interval seconds
+func (node *CouchbaseNode) SetExpiryPager(bucketname string, interval int) (err error) {
+	settings := fmt.Sprintf("-b %s set flush_param exp_pager_stime %d", bucketname, interval)
+	return node.runSSH(fmt.Sprintf("/opt/couchbase/bin/cbepctl localhost:11210 -u %s -p %s %s",
+		node.AdminUserName, node.AdminPassword, settings),
+		fmt.Sprintf("cbepctl %s", settings))
+}
+
 func (node *CouchbaseNode) DeleteBucket(bucketname string) (err error) {
 	api := fmt.Sprintf("%s/%s/%s", node.BaseURL, createBucketUri, bucketname)
 
 	req, err := http.NewRequest("DELETE", api, nil)
 	if err != nil {
-		fmt.Printf("Error forming the request %v", err)
+		logger.Printf(logger.ERR, "Error forming the request %v", err)
 		return err
 	}
 
 	resp, err := node.HttpClient.Do(req)
 
 	if err != nil {
-		fmt.Printf("\n error getting delete bucket response %v", err)
+		logger.Printf(logger.ERR, "error getting delete bucket response %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			fmt.Printf("\n error reading delete bucket response %s", body)
+			logger.Printf(logger.ERR, "error reading delete bucket response %s", body)
 		}
 		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
 	}
@@ -377,13 +501,20 @@ func (node *CouchbaseNode) DeleteBucket(bucketname string) (err error) {
 
 func (node *CouchbaseNode) CreateRemoteClusterReference(es *ESNode) (err error) {
 	values := url.Values{}
-	values.Set("name", "remote")
-	values.Set("hostname", fmt.Sprintf("%s:%s", es.Ip, es.ConnectorPort))
+	values.Set("name", remoteClusterName)
+	values.Set("hostname", es.ReplicationHost())
 	values.Set("username", es.AdminUserName)
 	values.Set("password", es.AdminPassword)
+	encryption, err := remoteClusterEncryption(es)
+	if err != nil {
+		return err
+	}
+	for key, value := range encryption {
+		values.Set(key, value)
+	}
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, remoteClusterUri)
-	fmt.Printf("\n Create Remote Cluster %s values %s \n", api, values.Encode())
+	logger.Printf(logger.DEBUG, "Create Remote Cluster %s hostname %s username %s", api, es.ReplicationHost(), es.AdminUserName)
 
 	req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -392,16 +523,16 @@ func (node *CouchbaseNode) CreateRemoteClusterReference(es *ESNode) (err error)
 
 	resp, err := node.HttpClient.Do(req)
 	if err != nil {
-		fmt.Printf("\n Unable to create remote cluster reference %v", err)
+		logger.Printf(logger.ERR, "Unable to create remote cluster reference %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		fmt.Printf("Got Bad HTTP response %v on adding remote cluster reference ", resp.Status)
+		logger.Printf(logger.ERR, "Got Bad HTTP response %v on adding remote cluster reference", resp.Status)
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
 			buf := bytes.NewBuffer(body)
-			fmt.Printf("\n response %v", buf.String())
+			logger.Printf(logger.DEBUG, "response %v", buf.String())
 		}
 		return err
 	}
@@ -413,12 +544,12 @@ func (node *CouchbaseNode) CreateReplication(bucket string, index string) (err e
 	values := url.Values{}
 	values.Set("fromBucket", bucket)
 	values.Set("toBucket", index)
-	values.Set("toCluster", "remote")
+	values.Set("toCluster", remoteClusterName)
 	values.Set("replicationType", "continuous")
 	values.Set("type", "capi")
 
 	api := fmt.Sprintf("%s%s", node.BaseURL, replicationUri)
-	fmt.Printf("\n Create Remote Cluster %s values %s \n", api, values.Encode())
+	logger.Printf(logger.DEBUG, "Create Remote Cluster %s values %s", api, values.Encode())
 
 	req, err := http.NewRequest("POST", api, strings.NewReader(values.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -429,10 +560,10 @@ func (node *CouchbaseNode) CreateReplication(bucket string, index string) (err e
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		fmt.Printf("Got Bad HTTP response %v on adding remote cluster reference ", resp.Status)
+		logger.Printf(logger.ERR, "Got Bad HTTP response %v on adding remote cluster reference", resp.Status)
 		if body, err := ioutil.ReadAll(resp.Body); err == nil {
 			buf := bytes.NewBuffer(body)
-			fmt.Printf("\n response %v", buf.String())
+			logger.Printf(logger.DEBUG, "response %v", buf.String())
 		}
 		return err
 	}
@@ -441,29 +572,177 @@ func (node *CouchbaseNode) CreateReplication(bucket string, index string) (err e
 }
 
 func (node *CouchbaseNode) StopService() (err error) {
-	config := &ssh.ClientConfig{
-		User: node.SSHUserName,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(node.SSHPassword),
-		},
+	command := "/etc/init.d/couchbase-server stop"
+	return node.runSSH(command, command)
+}
+
+func (node *CouchbaseNode) getJson(api string, v interface{}) (err error) {
+	resp, err := node.HttpClient.Get(api)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", node.Ip), config)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Printf(logger.ERR, "body of the response with error %s", body)
+		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
+	}
+	return json.Unmarshal(body, v)
+}
 
-	session, err := client.NewSession()
+func (node *CouchbaseNode) doDelete(api string) (err error) {
+	req, err := http.NewRequest("DELETE", api, nil)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	resp, err := node.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	command := "/etc/init.d/couchbase-server stop"
-	if err := session.Run(command); err != nil {
-		fmt.Printf("Failed to run command %s", command)
+	if resp.StatusCode != http.StatusOK {
+		if body, err := ioutil.ReadAll(resp.Body); err == nil {
+			logger.Printf(logger.ERR, "body of the response with error %s", body)
+		}
+		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
+	}
+	return nil
+}
+
+func (node *CouchbaseNode) ListBuckets() (buckets []string, err error) {
+	var resJson []struct {
+		Name string `json:"name"`
+	}
+	if err = node.getJson(fmt.Sprintf("%s%s", node.BaseURL, createBucketUri), &resJson); err != nil {
+		return nil, err
+	}
+	for _, bucket := range resJson {
+		buckets = append(buckets, bucket.Name)
+	}
+	return buckets, nil
+}
+
+func (node *CouchbaseNode) GetItemCount(bucketname string) (count int, err error) {
+	var resJson struct {
+		BasicStats struct {
+			ItemCount int `json:"itemCount"`
+		} `json:"basicStats"`
+	}
+	api := fmt.Sprintf("%s%s/%s", node.BaseURL, createBucketUri, bucketname)
+	if err = node.getJson(api, &resJson); err != nil {
+		return 0, err
+	}
+	return resJson.BasicStats.ItemCount, nil
+}
+
+// VBucketSeqno is the high seqno and vbucket uuid of a vbucket
+type VBucketSeqno struct {
+	VBucket   int
+	HighSeqno uint64
+	UUID      uint64
+}
+
+// VBucketSeqnos returns the high seqnos of the connected bucket by vbucket.
+// Replicas report too, the highest seqno of a vbucket is the active one.
+func (node *CouchbaseNode) VBucketSeqnos() (seqnos map[int]VBucketSeqno, err error) {
+	if node.Bucket == nil {
+		return nil, errors.New(fmt.Sprintf("Not connected to a bucket on %s", node.Ip))
+	}
+	stats := node.Bucket.GetStats("vbucket-seqno")
+	if len(stats) == 0 {
+		return nil, errors.New(fmt.Sprintf("No vbucket-seqno stats from %s", node.Ip))
+	}
+
+	type partial struct {
+		high, uuid uint64
+	}
+	seqnos = make(map[int]VBucketSeqno)
+	for _, serverStats := range stats {
+		vbuckets := make(map[int]*partial)
+		for key, value := range serverStats {
+			var vb int
+			var name string
+			if n, _ := fmt.Sscanf(strings.Replace(key, ":", " ", 1), "vb_%d %s", &vb, &name); n != 2 {
+				continue
+			}
+			number, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if vbuckets[vb] == nil {
+				vbuckets[vb] = &partial{}
+			}
+			switch name {
+			case "high_seqno":
+				vbuckets[vb].high = number
+			case "uuid":
+				vbuckets[vb].uuid = number
+			}
+		}
+		for vb, p := range vbuckets {
+			if current, ok := seqnos[vb]; !ok || p.high > current.HighSeqno {
+				seqnos[vb] = VBucketSeqno{VBucket: vb, HighSeqno: p.high, UUID: p.uuid}
+			}
+		}
+	}
+	return seqnos, nil
+}
+
+type XDCRTask struct {
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Status string `json:"status"`
+}
+
+// ListReplications returns the xdcr tasks running on the cluster
+func (node *CouchbaseNode) ListReplications() (replications []XDCRTask, err error) {
+	var tasks []struct {
+		Type string `json:"type"`
+		XDCRTask
+	}
+	if err = node.getJson(fmt.Sprintf("%s%s", node.BaseURL, tasksUri), &tasks); err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if task.Type == "xdcr" {
+			replications = append(replications, task.XDCRTask)
+		}
+	}
+	return replications, nil
+}
+
+func (node *CouchbaseNode) CancelReplication(id string) (err error) {
+	api := fmt.Sprintf("%s%s/%s", node.BaseURL, cancelXDCRUri, url.QueryEscape(id))
+	return node.doDelete(api)
+}
+
+// CancelReplicationsOf cancels the replications whose source is one of
+// buckets
+func (node *CouchbaseNode) CancelReplicationsOf(buckets []string) (err error) {
+	replications, err := node.ListReplications()
+	if err != nil {
 		return err
 	}
+	for _, replication := range replications {
+		for _, bucket := range buckets {
+			if replication.Source == bucket {
+				if err = node.CancelReplication(replication.Id); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
 	return nil
 }
+
+func (node *CouchbaseNode) DeleteRemoteClusterReference(name string) (err error) {
+	api := fmt.Sprintf("%s%s/%s", node.BaseURL, remoteClusterUri, url.QueryEscape(name))
+	return node.doDelete(api)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbaselabs/go-couchbase"
+	"io/ioutil"
+	"net/http"
+)
+
+const defaultCouchbaseTLSPort = "18091"
+
+// XDCR encryption types of a remote cluster reference
+const (
+	XDCREncryptionFull = "full"
+	XDCREncryptionHalf = "half"
+)
+
+func (node *CouchbaseNode) tlsPort() string {
+	if node.TLSPort != "" {
+		return node.TLSPort
+	}
+	return defaultCouchbaseTLSPort
+}
+
+// managementHost is the host:port the REST calls go to, the https port
+// when tls is on
+func (node *CouchbaseNode) managementHost() string {
+	if node.TLS {
+		return joinHostPort(node.Ip, node.tlsPort())
+	}
+	return joinHostPort(node.Ip, node.Port)
+}
+
+func (node *CouchbaseNode) scheme() string {
+	if node.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// newHttpClient builds the client of the REST calls, logging in with the
+// admin credentials and over https when tls is on
+func (node *CouchbaseNode) newHttpClient() (client *http.Client, err error) {
+	var base http.RoundTripper = http.DefaultTransport
+	if node.TLS {
+		tlsConfig, err := newTLSConfig(node.CAFile, node.ClientCertFile, node.ClientKeyFile, node.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		base = newTLSTransport(tlsConfig)
+	}
+	return &http.Client{
+		Transport: &authTransport{
+			base:     base,
+			username: node.AdminUserName,
+			password: node.AdminPassword,
+		},
+	}, nil
+}
+
+// setupKVTLS makes the data connections of go-couchbase use TLS. These
+// settings are global, every node of a run shares the same certificates.
+func (node *CouchbaseNode) setupKVTLS() {
+	if !node.TLS {
+		return
+	}
+	couchbase.SetSkipVerify(node.InsecureSkipVerify)
+	if node.CAFile != "" {
+		couchbase.SetRootFile(node.CAFile)
+	}
+	if node.ClientCertFile != "" {
+		couchbase.SetCertFile(node.ClientCertFile)
+		couchbase.SetKeyFile(node.ClientKeyFile)
+	}
+}
+
+// remoteClusterEncryption returns the parameters of an encrypted remote
+// cluster reference to es, none when its XDCR is not encrypted
+func remoteClusterEncryption(es *ESNode) (values map[string]string, err error) {
+	if es.XDCREncryption == "" {
+		return nil, nil
+	}
+	values = map[string]string{
+		"demandEncryption": "1",
+		"encryptionType":   es.XDCREncryption,
+	}
+	if es.XDCRCertificateFile != "" {
+		certificate, err := ioutil.ReadFile(es.XDCRCertificateFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to read the xdcr certificate %v", err))
+		}
+		values["certificate"] = string(certificate)
+	}
+	return values, nil
+}
+
+// validateCouchbaseTLS checks the TLS settings of a couchbase node
+func validateCouchbaseTLS(problems *ValidationError, path string, node CouchbaseNode) {
+	if !node.TLS {
+		if node.TLSPort != "" {
+			problems.add(path+".tls-port", "needs tls")
+		}
+		if node.CAFile != "" {
+			problems.add(path+".ca-file", "needs tls")
+		}
+		if node.ClientCertFile != "" {
+			problems.add(path+".client-cert-file", "needs tls")
+		}
+		if node.InsecureSkipVerify {
+			problems.add(path+".insecure-skip-verify", "needs tls")
+		}
+		return
+	}
+	if (node.ClientCertFile == "") != (node.ClientKeyFile == "") {
+		problems.add(path+".client-cert-file", "client-cert-file and client-key-file must be given together")
+	}
+	files := [][2]string{
+		{"ca-file", node.CAFile},
+		{"client-cert-file", node.ClientCertFile},
+		{"client-key-file", node.ClientKeyFile},
+	}
+	for _, file := range files {
+		if file[1] == "" {
+			continue
+		}
+		if _, err := ioutil.ReadFile(file[1]); err != nil {
+			problems.add(path+"."+file[0], "%v", err)
+		}
+	}
+}
+
+// validateXDCREncryption checks the XDCR encryption settings of an es node
+func validateXDCREncryption(problems *ValidationError, path string, node ESNode) {
+	switch node.XDCREncryption {
+	case "", XDCREncryptionFull, XDCREncryptionHalf:
+	default:
+		problems.add(path+".xdcr-encryption", "must be %s or %s, got %q",
+			XDCREncryptionFull, XDCREncryptionHalf, node.XDCREncryption)
+	}
+	if node.XDCREncryption == XDCREncryptionFull && node.XDCRCertificateFile == "" {
+		problems.add(path+".xdcr-certificate-file", "is required for full xdcr encryption")
+	}
+	if node.XDCRCertificateFile != "" {
+		if node.XDCREncryption == "" {
+			problems.add(path+".xdcr-certificate-file", "needs xdcr-encryption")
+		} else if _, err := ioutil.ReadFile(node.XDCRCertificateFile); err != nil {
+			problems.add(path+".xdcr-certificate-file", "%v", err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const poolsDefaultUri = "/pools/default"
+
+// Node status and cluster membership as /pools/default reports them
+const (
+	NodeHealthy   = "healthy"
+	NodeUnhealthy = "unhealthy"
+	NodeWarmup    = "warmup"
+
+	MembershipActive         = "active"
+	MembershipInactiveAdded  = "inactiveAdded"
+	MembershipInactiveFailed = "inactiveFailed"
+)
+
+// ClusterNode is a member of a cluster as the cluster sees it
+type ClusterNode struct {
+	OtpNode           string   `json:"otpNode"`
+	Hostname          string   `json:"hostname"`
+	Status            string   `json:"status"`
+	ClusterMembership string   `json:"clusterMembership"`
+	Services          []string `json:"services"`
+	ThisNode          bool     `json:"thisNode"`
+}
+
+// Host returns the hostname without its port
+func (n ClusterNode) Host() string {
+	if host, _, err := net.SplitHostPort(n.Hostname); err == nil {
+		return host
+	}
+	return strings.Trim(n.Hostname, "[]")
+}
+
+// Port returns the REST port of the hostname, empty when it has none
+func (n ClusterNode) Port() string {
+	if _, port, err := net.SplitHostPort(n.Hostname); err == nil {
+		return port
+	}
+	return ""
+}
+
+// Is tells whether the cluster node is the configured node. Nodes on one
+// host, as in container labs, are told apart by their REST port. The node
+// name is only used when the cluster reports no hostname.
+func (n ClusterNode) Is(node *CouchbaseNode) bool {
+	if n.Hostname == "" {
+		name := n.OtpNode[strings.Index(n.OtpNode, "@")+1:]
+		return sameHost(name, node.Ip)
+	}
+	if n.Port() != "" && node.Port != "" && n.Port() != node.Port {
+		return false
+	}
+	return sameHost(n.Host(), node.Ip)
+}
+
+// sameHost tells whether a and b are the same host, given as names or as
+// addresses in any notation
+func sameHost(a, b string) bool {
+	a, b = strings.Trim(a, "[]"), strings.Trim(b, "[]")
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	//Resolve the name to compare it with the address
+	name, ip := a, ipB
+	if ipA != nil {
+		name, ip = b, ipA
+	}
+	if ip == nil {
+		return false
+	}
+	addresses, err := net.LookupIP(name)
+	if err != nil {
+		return false
+	}
+	for _, address := range addresses {
+		if address.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinHostPort joins host and port, bracketing IPv6 addresses whether or
+// not they are configured with brackets
+func joinHostPort(host, port string) string {
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// ClusterNodes returns the members of the cluster of node, including the
+// nodes that are added or failed over but not rebalanced yet
+func (node *CouchbaseNode) ClusterNodes() (nodes []ClusterNode, err error) {
+	var pool struct {
+		Nodes []ClusterNode `json:"nodes"`
+	}
+	if err = node.getJson(fmt.Sprintf("%s%s", node.BaseURL, poolsDefaultUri), &pool); err != nil {
+		return nil, err
+	}
+	return pool.Nodes, nil
+}
+
+// ClusterNode returns how the cluster of node sees n
+func (node *CouchbaseNode) ClusterNode(n *CouchbaseNode) (member ClusterNode, err error) {
+	nodes, err := node.ClusterNodes()
+	if err != nil {
+		return member, err
+	}
+	for _, member := range nodes {
+		if member.Is(n) {
+			return member, nil
+		}
+	}
+	return member, errors.New(fmt.Sprintf("Node %s is not a member of the cluster of %s", n.Ip, node.Ip))
+}
+
+// ClusterMembers returns the candidates that are members of the cluster of
+// node
+func (node *CouchbaseNode) ClusterMembers(candidates []*CouchbaseNode) (members []*CouchbaseNode, err error) {
+	nodes, err := node.ClusterNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		for _, member := range nodes {
+			if member.Is(candidate) {
+				members = append(members, candidate)
+				break
+			}
+		}
+	}
+	return members, nil
+}
+
+// rebalanceNodes computes the knownNodes and ejectedNodes of a rebalance
+// from the cluster. Every member is known, the nodes in eject and the ones
+// that were failed over are ejected.
+func rebalanceNodes(members []ClusterNode, eject []*CouchbaseNode) (known []string, ejected []string, err error) {
+	for _, member := range members {
+		known = append(known, member.OtpNode)
+		if member.ClusterMembership == MembershipInactiveFailed {
+			ejected = append(ejected, member.OtpNode)
+			continue
+		}
+		for _, n := range eject {
+			if member.Is(n) {
+				ejected = append(ejected, member.OtpNode)
+				break
+			}
+		}
+	}
+
+	for _, n := range eject {
+		found := false
+		for _, member := range members {
+			if member.Is(n) {
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, errors.New(fmt.Sprintf("Node %s to eject is not a member of the cluster", n.Ip))
+		}
+	}
+	return known, ejected, nil
+}
+
+// checkMembership returns an error unless every node in nodes has the
+// membership of want in the cluster of ept, an empty want means the node
+// must have left the cluster
+func checkMembership(ept *CouchbaseNode, nodes []*CouchbaseNode, want string) (err error) {
+	members, err := ept.ClusterNodes()
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, n := range nodes {
+		membership := ""
+		for _, member := range members {
+			if member.Is(n) {
+				membership = member.ClusterMembership
+			}
+		}
+		if membership != want {
+			if want == "" {
+				problems = append(problems, fmt.Sprintf("%s is still %s", n.Ip, membership))
+			} else if membership == "" {
+				problems = append(problems, fmt.Sprintf("%s is not a member", n.Ip))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s is %s", n.Ip, membership))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("Cluster of %s after rebalance: %s", ept.Ip, strings.Join(problems, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRebalanceNodes(t *testing.T) {
+	members := []ClusterNode{
+		{OtpNode: "ns_1@10.1.2.10", Hostname: "10.1.2.10:8091", ClusterMembership: MembershipActive},
+		{OtpNode: "ns_1@10.1.2.11", Hostname: "10.1.2.11:8091", ClusterMembership: MembershipActive},
+		{OtpNode: "ns_1@10.1.2.12", Hostname: "10.1.2.12:8091", ClusterMembership: MembershipInactiveFailed},
+		{OtpNode: "ns_1@10.1.2.13", Hostname: "10.1.2.13:8091", ClusterMembership: MembershipInactiveAdded},
+	}
+	known := []string{"ns_1@10.1.2.10", "ns_1@10.1.2.11", "ns_1@10.1.2.12", "ns_1@10.1.2.13"}
+
+	for _, c := range []struct {
+		name    string
+		eject   []*CouchbaseNode
+		ejected []string
+		err     string
+	}{
+		{"failed over nodes are ejected", nil, []string{"ns_1@10.1.2.12"}, ""},
+		{"eject a node", []*CouchbaseNode{{Ip: "10.1.2.11", Port: "8091"}},
+			[]string{"ns_1@10.1.2.11", "ns_1@10.1.2.12"}, ""},
+		{"eject a node without port", []*CouchbaseNode{{Ip: "10.1.2.13"}},
+			[]string{"ns_1@10.1.2.12", "ns_1@10.1.2.13"}, ""},
+		{"eject a failed over node", []*CouchbaseNode{{Ip: "10.1.2.12", Port: "8091"}},
+			[]string{"ns_1@10.1.2.12"}, ""},
+		{"eject a node of another cluster", []*CouchbaseNode{{Ip: "10.1.2.20", Port: "8091"}},
+			nil, "Node 10.1.2.20 to eject is not a member"},
+		{"eject on another port", []*CouchbaseNode{{Ip: "10.1.2.11", Port: "9000"}},
+			nil, "Node 10.1.2.11 to eject is not a member"},
+	} {
+		gotKnown, ejected, err := rebalanceNodes(members, c.eject)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, expected %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotKnown, known) || !reflect.DeepEqual(ejected, c.ejected) {
+			t.Errorf("%s: known %v ejected %v, expected ejected %v", c.name, gotKnown, ejected, c.ejected)
+		}
+	}
+}
+
+func TestClusterNodeIs(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		member ClusterNode
+		node   CouchbaseNode
+		is     bool
+	}{
+		{"same address and port", ClusterNode{Hostname: "10.1.2.10:8091"}, CouchbaseNode{Ip: "10.1.2.10", Port: "8091"}, true},
+		{"other address", ClusterNode{Hostname: "10.1.2.10:8091"}, CouchbaseNode{Ip: "10.1.2.11", Port: "8091"}, false},
+		{"configured without port", ClusterNode{Hostname: "10.1.2.10:8091"}, CouchbaseNode{Ip: "10.1.2.10"}, true},
+		{"cluster_run nodes on one host", ClusterNode{Hostname: "127.0.0.1:9001"}, CouchbaseNode{Ip: "127.0.0.1", Port: "9000"}, false},
+		{"cluster_run node", ClusterNode{Hostname: "127.0.0.1:9001"}, CouchbaseNode{Ip: "127.0.0.1", Port: "9001"}, true},
+		{"IPv6 with brackets", ClusterNode{Hostname: "[fd00::10]:8091"}, CouchbaseNode{Ip: "[fd00::10]", Port: "8091"}, true},
+		{"IPv6 without brackets", ClusterNode{Hostname: "[fd00::10]:8091"}, CouchbaseNode{Ip: "fd00:0:0::10", Port: "8091"}, true},
+		{"hostname", ClusterNode{Hostname: "cb1.example.com:8091"}, CouchbaseNode{Ip: "CB1.example.com", Port: "8091"}, true},
+		{"hostname without port", ClusterNode{Hostname: "cb1.example.com"}, CouchbaseNode{Ip: "cb1.example.com", Port: "8091"}, true},
+		{"otpNode without hostname", ClusterNode{OtpNode: "ns_1@10.1.2.10"}, CouchbaseNode{Ip: "10.1.2.10", Port: "8091"}, true},
+		{"otpNode of another node", ClusterNode{OtpNode: "ns_1@10.1.2.10"}, CouchbaseNode{Ip: "10.1.2.11"}, false},
+	} {
+		if is := c.member.Is(&c.node); is != c.is {
+			t.Errorf("%s: %s is %s:%s is %v, expected %v", c.name, c.member.Hostname, c.node.Ip, c.node.Port, is, c.is)
+		}
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		same bool
+	}{
+		{"10.1.2.10", "10.1.2.10", true},
+		{"10.1.2.10", "10.1.2.11", false},
+		{"::1", "[0:0:0:0:0:0:0:1]", true},
+		{"::ffff:10.1.2.10", "10.1.2.10", true},
+		{"Node1.Example.COM", "node1.example.com", true},
+		{"localhost", "127.0.0.1", true},
+		{"127.0.0.1", "localhost", true},
+		{"localhost", "10.1.2.10", false},
+		{"node1.invalid", "node2.invalid", false},
+	} {
+		if same := sameHost(c.a, c.b); same != c.same {
+			t.Errorf("%s and %s are the same host %v, expected %v", c.a, c.b, same, c.same)
+		}
+	}
+}
+
+func TestJoinHostPort(t *testing.T) {
+	for _, c := range []struct {
+		host, port, joined string
+	}{
+		{"10.1.2.10", "8091", "10.1.2.10:8091"},
+		{"fd00::10", "8091", "[fd00::10]:8091"},
+		{"[fd00::10]", "8091", "[fd00::10]:8091"},
+		{"cb1.example.com", "18091", "cb1.example.com:18091"},
+	} {
+		if joined := joinHostPort(c.host, c.port); joined != c.joined {
+			t.Errorf("%s %s joined to %s, expected %s", c.host, c.port, joined, c.joined)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigFile     = "config.json"
+	defaultActionsFile    = "resources/data-manipulation-options.json"
+	defaultSituationsFile = "resources/situation-options.json"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "Run the configured situation and data manipulation", runCommand},
+		{"list", "List the available situations and data manipulations", listCommand},
+		{"validate", "Check a config without touching the clusters", validateCommand},
+		{"cleanup", "Delete buckets, indexes and replications left behind by a run", cleanupCommand},
+		{"verify", "Compare item counts of existing buckets with their indexes", verifyCommand},
+		{"checkpoints", "Show how far the connector checkpoints are behind the buckets", checkpointsCommand},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
+
+// runCli dispatches to the subcommand and returns the exit code. Without a
+// subcommand the configured run is executed as before.
+func runCli(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runCommand(args)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	if args[0] == "help" {
+		usage()
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", args[0])
+	usage()
+	return 2
+}
+
+type standardFiles struct {
+	config     *string
+	actions    *string
+	situations *string
+}
+
+func addStandardFlags(fs *flag.FlagSet) (files standardFiles) {
+	files.config = fs.String("config", defaultConfigFile, "Config file")
+	files.actions = fs.String("actions", defaultActionsFile, "Data manipulation options file")
+	files.situations = fs.String("situations", defaultSituationsFile, "Situation options file")
+	return files
+}
+
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	files := addStandardFlags(fs)
+	situations := fs.String("situation", "", "Comma separated situations to run instead of the configured cluster-situation")
+	actions := fs.String("action", "", "Comma separated data manipulations instead of the configured ones")
+	fs.Parse(args)
+
+	start := time.Now()
+	config, err := LoadConfig(*files.config, *files.actions, *files.situations,
+		ConfigOverrides{SituationIds: splitList(*situations), ActionIds: splitList(*actions)})
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+
+	l, err := setupLogger(config.Log)
+	if err != nil {
+		logger.Fatalf("Unable to set up logging %v", err)
+	}
+	defer l.Close()
+
+	results := RunMatrix(&config)
+	passed := PrintSummary(results)
+
+	duration := time.Since(start)
+	logger.Printf(logger.INFO, "Time taken for Execution of the tests %s", duration.String())
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+func splitList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func listCommand(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	files := addStandardFlags(fs)
+	fs.Parse(args)
+
+	var situations []Situation
+	if err := readSituationOptions(*files.situations, &situations); err != nil {
+		return 1
+	}
+	var actions []Action
+	if err := readActionOptions(*files.actions, &actions); err != nil {
+		return 1
+	}
+
+	fmt.Printf("Situations (cluster-situation):\n")
+	for _, s := range situations {
+		fmt.Printf("  %-12s %s (nodes %d, add %d, remove %d, failover %d)\n",
+			s.Id, s.Description, s.NodeCount, s.AddCount, s.RemoveCount, s.FailoverCount)
+	}
+	fmt.Printf("\nData manipulations (data-manipulation):\n")
+	for _, a := range actions {
+		fmt.Printf("  %-12s %s\n", a.Id, a.Description)
+	}
+	return 0
+}
+
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	files := addStandardFlags(fs)
+	fs.Parse(args)
+
+	config, err := LoadConfig(*files.config, *files.actions, *files.situations, ConfigOverrides{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *files.config, err)
+		return 1
+	}
+	fmt.Printf("%s is valid: %d runs over %d situations and %d data manipulations, %d couchbase nodes, %d elastic search nodes\n",
+		*files.config, len(ExpandMatrix(&config)), len(config.situation), len(config.actions), len(config.CBNodes), len(config.ESNodes))
+	return 0
+}
+
+func isHarnessBucket(bucket string) bool {
+	return strings.HasPrefix(bucket, CouchbaseBucketSeed+"-") ||
+		strings.HasPrefix(bucket, MemcachedBucketSeed+"-") ||
+		bucket == PassthroughBucket
+}
+
+func isHarnessIndex(index string) bool {
+	return strings.HasPrefix(index, IndexSeed+"-") || index == PassthroughIndex
+}
+
+// indexFor returns the index the harness replicates bucket to
+func indexFor(bucket string) string {
+	if bucket == PassthroughBucket {
+		return PassthroughIndex
+	}
+	for _, seed := range []string{CouchbaseBucketSeed, MemcachedBucketSeed} {
+		if strings.HasPrefix(bucket, seed+"-") {
+			return IndexSeed + bucket[len(seed):]
+		}
+	}
+	return bucket
+}
+
+func cleanupCommand(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "Config file")
+	dryRun := fs.Bool("dry-run", false, "Only print what would be deleted")
+	fs.Parse(args)
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	if len(config.CBNodes) == 0 || len(config.ESNodes) == 0 {
+		logger.Printf(logger.ERR, "Config needs at least one couchbase and one elastic search node")
+		return 1
+	}
+	cb := &config.CBNodes[0]
+	if err = cb.Connect(); err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	cluster, err := ConnectES(&config)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	es := cluster.Client()
+
+	failed := false
+	remove := func(what string, name string, fn func() error) {
+		if *dryRun {
+			fmt.Printf("Would delete %s %s\n", what, name)
+			return
+		}
+		if err := fn(); err != nil {
+			logger.Printf(logger.ERR, "Error deleting %s %s %v", what, name, err)
+			failed = true
+			return
+		}
+		logger.Printf(logger.INFO, "Deleted %s %s", what, name)
+	}
+
+	buckets, err := cb.ListBuckets()
+	if err != nil {
+		logger.Printf(logger.ERR, "Error listing buckets %v", err)
+		return 1
+	}
+
+	replications, err := cb.ListReplications()
+	if err != nil {
+		logger.Printf(logger.ERR, "Error listing replications %v", err)
+		failed = true
+	}
+	for _, replication := range replications {
+		if isHarnessBucket(replication.Source) {
+			id := replication.Id
+			remove("replication", id, func() error { return cb.CancelReplication(id) })
+		}
+	}
+	remove("remote cluster reference", remoteClusterName, func() error { return cb.DeleteRemoteClusterReference(remoteClusterName) })
+
+	for _, bucket := range buckets {
+		if isHarnessBucket(bucket) {
+			name := bucket
+			remove("bucket", name, func() error { return cb.DeleteBucket(name) })
+		}
+	}
+
+	indexes, err := es.ListIndexes()
+	if err != nil {
+		logger.Printf(logger.ERR, "Error listing indexes %v", err)
+		return 1
+	}
+	for _, index := range indexes {
+		if isHarnessIndex(index) {
+			name := index
+			remove("index", name, func() error { return es.DeleteIndex(name) })
+		}
+	}
+	if config.Elastic != nil && config.Elastic.Index != nil && config.Elastic.Index.TemplateFile != "" {
+		name := config.Elastic.Index.templateName()
+		remove("template", name, func() error { return es.DeleteTemplate(name) })
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func verifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "Config file")
+	timeout := fs.Duration("timeout", maxWaitTimeForReplication, "How long to wait for the counts to converge")
+	fs.Parse(args)
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	if len(config.CBNodes) == 0 || len(config.ESNodes) == 0 {
+		logger.Printf(logger.ERR, "Config needs at least one couchbase and one elastic search node")
+		return 1
+	}
+	cb := &config.CBNodes[0]
+	if err = cb.Connect(); err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	cluster, err := ConnectES(&config)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	es := cluster.Client()
+
+	buckets, err := cb.ListBuckets()
+	if err != nil {
+		logger.Printf(logger.ERR, "Error listing buckets %v", err)
+		return 1
+	}
+
+	passed := true
+	fmt.Printf("%-16s %-16s %10s %10s %s\n", "bucket", "index", "items", "indexed", "result")
+	for _, bucket := range buckets {
+		if !isHarnessBucket(bucket) {
+			continue
+		}
+		index := indexFor(bucket)
+		deadline := time.Now().Add(*timeout)
+
+		var itemCount, indexedCount int
+		for {
+			if itemCount, err = cb.GetItemCount(bucket); err == nil {
+				indexedCount, err = es.GetCount(index)
+			}
+			if (err == nil && itemCount == indexedCount) || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+
+		result := "PASS"
+		if err != nil {
+			result = fmt.Sprintf("ERROR %v", err)
+			passed = false
+		} else if itemCount != indexedCount {
+			result = "FAIL"
+			passed = false
+		}
+		fmt.Printf("%-16s %-16s %10d %10d %s\n", bucket, index, itemCount, indexedCount, result)
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+func checkpointsCommand(args []string) int {
+	fs := flag.NewFlagSet("checkpoints", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "Config file")
+	timeout := fs.Duration("timeout", 0, "How long to wait for the checkpoints to catch up")
+	verbose := fs.Bool("v", false, "Show every vbucket that is behind")
+	fs.Parse(args)
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	if len(config.CBNodes) == 0 || len(config.ESNodes) == 0 {
+		logger.Printf(logger.ERR, "Config needs at least one couchbase and one elastic search node")
+		return 1
+	}
+	cb := &config.CBNodes[0]
+	if err = cb.Connect(); err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	cluster, err := ConnectES(&config)
+	if err != nil {
+		logger.Printf(logger.ERR, "%v", err)
+		return 1
+	}
+	es := cluster.Client()
+
+	buckets, err := cb.ListBuckets()
+	if err != nil {
+		logger.Printf(logger.ERR, "Error listing buckets %v", err)
+		return 1
+	}
+
+	passed := true
+	fmt.Printf("%-16s %-16s %10s %8s %8s %s\n", "bucket", "index", "behind", "missing", "stale", "result")
+	for _, bucket := range buckets {
+		if !isHarnessBucket(bucket) {
+			continue
+		}
+		index := indexFor(bucket)
+
+		var lag CheckpointLag
+		if err = cb.ConnectToBucket(bucket); err == nil {
+			lag, err = WaitForCheckpoints(cb, es, index, *timeout)
+		}
+		if err != nil {
+			fmt.Printf("%-16s %-16s %10s %8s %8s ERROR %v\n", bucket, index, "-", "-", "-", err)
+			passed = false
+			continue
+		}
+
+		result := "PASS"
+		if !lag.Converged() {
+			result = "BEHIND"
+			passed = false
+		}
+		fmt.Printf("%-16s %-16s %10d %8d %8d %s\n", bucket, index, lag.Behind, lag.Missing, lag.Stale, result)
+		if *verbose {
+			for _, vb := range lag.VBuckets {
+				if vb.Behind > 0 || vb.Stale {
+					fmt.Printf("    vb %4d high %10d checkpoint %10d behind %10d missing %v stale %v\n",
+						vb.VBucket, vb.HighSeqno, vb.Checkpoint, vb.Behind, vb.Missing, vb.Stale)
+				}
+			}
+		}
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bsubhashni/go-cbes/docgen"
+	"github.com/bsubhashni/go-cbes/loader"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/secret"
+	"github.com/bsubhashni/go-cbes/workload"
 	"io/ioutil"
-	"log"
 	"strings"
+	"time"
 )
 
 const (
@@ -14,35 +19,135 @@ const (
 	MemcachedBucketSeed = "MemdBucket"
 	IndexSeed           = "index"
 	KeySeed             = "SimpleKey"
+	PassthroughBucket   = "NewBucket"
+	PassthroughIndex    = "newindex"
 )
 
 type Replication struct {
-	BucketType string `json:"bucket-type"`
-	ItemCount  int    `json:"item-count"`
-	ItemSize   int    `json:"item-size"`
+	BucketType string          `json:"bucket-type"`
+	ItemCount  int             `json:"item-count"`
+	ItemSize   int             `json:"item-size"`
+	Document   *docgen.Options `json:"document"`
+	Dataset    *loader.Options `json:"dataset"`
 }
 
 type Config struct {
-	Replications []Replication   `json:"replication"`
-	CBNodes      []CouchbaseNode `json:"cb-nodes"`
-	ESNodes      []ESNode        `json:"es-nodes"`
-	SituationId  string          `json:"cluster-situation"`
-	ActionId     string          `json:"data-manipulation"`
+	Replications []Replication     `json:"replication"`
+	Inventory    string            `json:"inventory"`
+	CBNodes      []CouchbaseNode   `json:"cb-nodes"`
+	ESNodes      []ESNode          `json:"es-nodes"`
+	SituationIds StringList        `json:"cluster-situation"`
+	ActionIds    StringList        `json:"data-manipulation"`
+	Workload     *workload.Options `json:"workload"`
+	Values       *ValueOptions     `json:"values"`
+	Elastic      *ElasticOptions   `json:"elastic"`
+	Proxy        *ProxyOptions     `json:"proxy"`
+	Log          *LogOptions       `json:"log"`
 	situation    []Situation
 	action       *Action
+	actions      []Action
 	executors    []Executor
+	runId        int
 }
 
+// StringList accepts either a single string or a list of strings, so a
+// config can name one situation or a whole matrix of them
+type StringList []string
+
+func (list *StringList) UnmarshalJSON(data []byte) (err error) {
+	var single string
+	if err = json.Unmarshal(data, &single); err == nil {
+		*list = StringList{single}
+		return nil
+	}
+	var multiple []string
+	if err = json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*list = StringList(multiple)
+	return nil
+}
+
+// BucketName is the bucket of the index-th replication in this run, named
+// after the bucket type and the run so that runs do not share buckets
+func (config *Config) BucketName(index int) string {
+	seed := CouchbaseBucketSeed
+	if strings.EqualFold(config.Replications[index].BucketType, "memcached") {
+		seed = MemcachedBucketSeed
+	}
+	return fmt.Sprintf("%s-%d-%d", seed, config.runId, index)
+}
+
+// Generator returns the document generator of the index-th replication.
+// item-size gives the size of every document unless the document options
+// set their own sizes.
+func (config *Config) Generator(index int) *docgen.Generator {
+	var options docgen.Options
+	if document := config.Replications[index].Document; document != nil {
+		options = *document
+	}
+	if options.MaxSize == 0 {
+		options.MinSize = config.Replications[index].ItemSize
+		options.MaxSize = config.Replications[index].ItemSize
+	}
+	return docgen.New(options)
+}
+
+// IndexName is the index the index-th replication of this run goes to
+func (config *Config) IndexName(index int) string {
+	return fmt.Sprintf("%s-%d-%d", IndexSeed, config.runId, index)
+}
+
+// ElasticOptions set the health the elastic search cluster and the indexes
+// have to reach before a run uses them and how the indexes are created.
+// health-timeout is in seconds.
+type ElasticOptions struct {
+	HealthStatus  string        `json:"health-status"`
+	HealthTimeout int           `json:"health-timeout"`
+	Index         *IndexOptions `json:"index"`
+}
+
+// WaitForHealth waits for the cluster, or index when it is not empty, to
+// reach the configured health
+func (config *Config) WaitForHealth(es *ESNode, index string) (err error) {
+	status, timeout := defaultHealthStatus, defaultHealthTimeout
+	if options := config.Elastic; options != nil {
+		if options.HealthStatus != "" {
+			status = options.HealthStatus
+		}
+		if options.HealthTimeout > 0 {
+			timeout = time.Duration(options.HealthTimeout) * time.Second
+		}
+	}
+	health, err := es.WaitForHealth(index, status, timeout)
+	if err != nil {
+		return err
+	}
+	logger.Printf(logger.DEBUG, "Health of %s on %s is %s", index, es.Ip, health.Status)
+	return nil
+}
+
+type LogOptions struct {
+	ErrorFile string `json:"error-file"`
+	InfoFile  string `json:"info-file"`
+	DebugFile string `json:"debug-file"`
+	Syslog    string `json:"syslog"`
+	Verbose   bool   `json:"verbose"`
+}
+
+// Action is a data manipulation, its mix replaces the one of the workload
+// section while it runs
 type Action struct {
-	Id          string `json:"id"`
-	Description string `json:"description"`
+	Id          string        `json:"id"`
+	Description string        `json:"description"`
+	Mix         *workload.Mix `json:"mix"`
 }
 
 type Situation struct {
 	Id            string `json:"id"`
 	Description   string `json:"description"`
 	NodeCount     int    `json:"node-count"`
-	ReplicaCount  int    `json:"replication-count"`
+	ReplicaCount  int    `json:"replica-count"`
 	FailoverCount int    `json:"failover-count"`
 	AddCount      int    `json:"add-count"`
 	RemoveCount   int    `json:"remove-count"`
@@ -51,11 +156,12 @@ type Situation struct {
 func readSituationOptions(situationsStandard string, situations *[]Situation) (err error) {
 	bytes, err := ioutil.ReadFile(situationsStandard)
 	if err != nil {
-		fmt.Printf("\n Error reading file %s %v", situationsStandard, err)
+		logger.Printf(logger.ERR, "Error reading file %s %v", situationsStandard, err)
+		return err
 	}
 
-	if err := json.Unmarshal(bytes, situations); err != nil {
-		fmt.Printf("\nError unmarshaling situation options %v", err)
+	if err := decodeStrict(bytes, situations, situationsStandard); err != nil {
+		logger.Printf(logger.ERR, "Error unmarshaling situation options %v", err)
 		return err
 	}
 	return nil
@@ -64,75 +170,197 @@ func readSituationOptions(situationsStandard string, situations *[]Situation) (e
 func readActionOptions(dmStandard string, actions *[]Action) (err error) {
 	bytes, err := ioutil.ReadFile(dmStandard)
 	if err != nil {
-		fmt.Printf("\n Error reading file %s %v", dmStandard, err)
+		logger.Printf(logger.ERR, "Error reading file %s %v", dmStandard, err)
 		return err
 	}
 
-	if err := json.Unmarshal(bytes, actions); err != nil {
-		fmt.Printf("\nError unmarshaling data manipulation options %v", err)
+	if err := decodeStrict(bytes, actions, dmStandard); err != nil {
+		logger.Printf(logger.ERR, "Error unmarshaling data manipulation options %v", err)
 		return err
 	}
 	return nil
 }
 
 func mapSituation(config *Config, situations *[]Situation) (err error) {
+	var problems ValidationError
+	var known []string
 	for _, situation := range *situations {
-		if strings.EqualFold(config.SituationId, situation.Id) {
-			config.situation = append(config.situation, situation)
+		known = append(known, situation.Id)
+	}
+	for index, id := range config.SituationIds {
+		found := false
+		for _, situation := range *situations {
+			if strings.EqualFold(id, situation.Id) {
+				config.situation = append(config.situation, situation)
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems.add(fmt.Sprintf("cluster-situation[%d]", index),
+				"unknown situation %q, expected one of %s", id, strings.Join(known, ", "))
 		}
 	}
-	if len(config.situation) == 0 {
-		return errors.New("Cannot map data-manipulation action with the standards")
-	} else {
-		return nil
+	if len(config.SituationIds) == 0 {
+		problems.add("cluster-situation", "is required")
+	}
+	if len(problems) > 0 {
+		return problems
 	}
+	return nil
 }
 
 func mapAction(config *Config, actions *[]Action) (err error) {
+	var problems ValidationError
+	var known []string
 	for _, action := range *actions {
-		if strings.EqualFold(config.ActionId, action.Id) {
-			config.action = &action
-			return nil
+		known = append(known, action.Id)
+	}
+	for index, id := range config.ActionIds {
+		found := false
+		for _, action := range *actions {
+			if strings.EqualFold(id, action.Id) {
+				config.actions = append(config.actions, action)
+				found = true
+				break
+			}
 		}
+		if !found {
+			problems.add(fmt.Sprintf("data-manipulation[%d]", index),
+				"unknown data manipulation %q, expected one of %s", id, strings.Join(known, ", "))
+		}
+	}
+	if len(config.ActionIds) == 0 {
+		problems.add("data-manipulation", "is required")
 	}
-	return errors.New("Cannot map data-manipulation action with the standards")
+	if len(problems) > 0 {
+		return problems
+	}
+	config.action = &config.actions[0]
+	return nil
+}
+
+// ConfigOverrides replace the situations and actions of the config file
+type ConfigOverrides struct {
+	SituationIds []string
+	ActionIds    []string
+}
+
+// LoadConfig reads, maps and validates the config. All problems found are
+// returned together as a ValidationError.
+func LoadConfig(fileName string, dmStandard string, situationsStandard string, overrides ConfigOverrides) (config Config, err error) {
+	var problems ValidationError
+
+	config, err = ReadConfig(fileName)
+	if err = problems.merge(err); err != nil {
+		return config, err
+	}
+
+	if len(overrides.SituationIds) > 0 {
+		config.SituationIds = overrides.SituationIds
+	}
+	if len(overrides.ActionIds) > 0 {
+		config.ActionIds = overrides.ActionIds
+	}
+
+	if err = problems.merge(MapStandards(&config, dmStandard, situationsStandard)); err != nil {
+		return config, err
+	}
+	if err = problems.merge(ValidateConfig(&config)); err != nil {
+		return config, err
+	}
+
+	if len(problems) > 0 {
+		return config, problems
+	}
+	return config, nil
 }
 
-func LoadConfig(fileName string, dmStandard string, situationsStandard string) (config Config) {
+// ReadConfig reads the config file without mapping it to the standards.
+// Unknown fields are returned as a ValidationError.
+func ReadConfig(fileName string) (config Config, err error) {
 	bytes, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		log.Fatalf("Error reading file %v", err)
+		return config, errors.New(fmt.Sprintf("Error reading file %v", err))
 	}
 
 	//Read config
-	if err := json.Unmarshal(bytes, &config); err != nil {
-		log.Fatalf("Error unmarshaling config %v", err)
+	if err = decodeStrict(bytes, &config, ""); err != nil {
+		return config, err
+	}
+	if err = loadInventory(&config); err != nil {
+		return config, err
+	}
+	if err = resolveCredentials(&config); err != nil {
+		return config, err
 	}
+	return config, nil
+}
 
+// resolveCredentials replaces env: and file: references in the credential
+// fields of the nodes by their values. Passwords are redacted from the logs.
+func resolveCredentials(config *Config) (err error) {
+	var problems ValidationError
+	resolve := func(path string, field *string, password bool) {
+		var value string
+		if password {
+			value, err = secret.ResolvePassword(*field)
+		} else {
+			value, err = secret.Resolve(*field)
+		}
+		if err != nil {
+			problems.add(path, "%v", err)
+			return
+		}
+		*field = value
+	}
+
+	for index := range config.CBNodes {
+		node := &config.CBNodes[index]
+		path := fmt.Sprintf("cb-nodes[%d]", index)
+		resolve(path+".username", &node.AdminUserName, false)
+		resolve(path+".password", &node.AdminPassword, true)
+		resolve(path+".ssh-username", &node.SSHUserName, false)
+		resolve(path+".ssh-password", &node.SSHPassword, true)
+	}
+	for index := range config.ESNodes {
+		node := &config.ESNodes[index]
+		path := fmt.Sprintf("es-nodes[%d]", index)
+		resolve(path+".username", &node.AdminUserName, false)
+		resolve(path+".password", &node.AdminPassword, true)
+		resolve(path+".http-username", &node.HttpUserName, false)
+		resolve(path+".http-password", &node.HttpPassword, true)
+		resolve(path+".api-key", &node.ApiKey, true)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// MapStandards maps the situation and action ids of the config to the
+// standard situations and actions
+func MapStandards(config *Config, dmStandard string, situationsStandard string) (err error) {
 	//Read Standards
 	var actions []Action
 	err = readActionOptions(dmStandard, &actions)
 	if err != nil {
-		log.Fatalf("%v", err)
+		return err
 	}
 
 	var situations []Situation
 	err = readSituationOptions(situationsStandard, &situations)
 	if err != nil {
-		log.Fatalf("%v", err)
+		return err
 	}
 
 	//Map to standard
-	err = mapAction(&config, &actions)
-
-	if err != nil {
-		log.Fatalf("%v", err)
+	var problems ValidationError
+	problems.merge(mapAction(config, &actions))
+	problems.merge(mapSituation(config, &situations))
+	if len(problems) > 0 {
+		return problems
 	}
-
-	err = mapSituation(&config, &situations)
-
-	if err != nil {
-		log.Fatalf("%v", err)
-	}
-	return config
+	return nil
 }
@@ -0,0 +1,247 @@
+package docgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultKeyPattern = "key_%d"
+	DefaultFields     = 4
+	DefaultDepth      = 1
+
+	// Distributions of the document size
+	Fixed   = "fixed"
+	Uniform = "uniform"
+	Normal  = "normal"
+)
+
+var FieldTypes = []string{"string", "int", "float", "bool", "date", "array", "object"}
+
+var words = []string{
+	"couchbase", "elastic", "replication", "bucket", "index", "cluster",
+	"node", "shard", "vbucket", "document", "mapping", "search", "query",
+	"rebalance", "failover", "checkpoint", "sequence", "stream",
+}
+
+// Options describe the documents of a replication. Sizes are in bytes of
+// the JSON encoded document.
+type Options struct {
+	Seed         int64    `json:"seed"`
+	KeyPattern   string   `json:"key-pattern"`
+	MinSize      int      `json:"min-size"`
+	MaxSize      int      `json:"max-size"`
+	Distribution string   `json:"distribution"`
+	Depth        int      `json:"depth"`
+	Fields       int      `json:"fields"`
+	FieldTypes   []string `json:"field-types"`
+}
+
+// Problem is an invalid option, Field is its JSON name
+type Problem struct {
+	Field   string
+	Message string
+}
+
+// Validate returns every invalid option
+func (o *Options) Validate() (problems []Problem) {
+	if o.KeyPattern != "" && strings.Count(o.KeyPattern, "%") != 1 {
+		problems = append(problems, Problem{"key-pattern", "must contain exactly one verb for the document number, such as %d"})
+	}
+	if o.MinSize < 0 {
+		problems = append(problems, Problem{"min-size", "must not be negative"})
+	}
+	if o.MaxSize < o.MinSize {
+		problems = append(problems, Problem{"max-size", "must not be smaller than min-size"})
+	}
+	switch o.Distribution {
+	case "", Fixed, Uniform, Normal:
+	default:
+		problems = append(problems, Problem{"distribution", fmt.Sprintf("must be %s, %s or %s, got %q", Fixed, Uniform, Normal, o.Distribution)})
+	}
+	if o.Depth < 0 {
+		problems = append(problems, Problem{"depth", "must not be negative"})
+	}
+	if o.Fields < 0 {
+		problems = append(problems, Problem{"fields", "must not be negative"})
+	}
+	for index, t := range o.FieldTypes {
+		if !known(t) {
+			problems = append(problems, Problem{fmt.Sprintf("field-types[%d]", index),
+				fmt.Sprintf("unknown type %q, expected one of %s", t, strings.Join(FieldTypes, ", "))})
+		}
+	}
+	return problems
+}
+
+func known(t string) bool {
+	for _, k := range FieldTypes {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+type field struct {
+	name   string
+	kind   string
+	fields []field
+}
+
+// Generator creates the documents of a replication. The field names and
+// types are fixed by the seed, so every document maps the same way in
+// elastic search, while the values and the size vary per document. The
+// same seed, number and version always give the same document, which lets
+// verification regenerate the expected bodies.
+type Generator struct {
+	options Options
+	schema  []field
+}
+
+func New(options Options) *Generator {
+	if options.KeyPattern == "" {
+		options.KeyPattern = DefaultKeyPattern
+	}
+	if options.Fields == 0 {
+		options.Fields = DefaultFields
+	}
+	if options.Depth == 0 {
+		options.Depth = DefaultDepth
+	}
+	if len(options.FieldTypes) == 0 {
+		options.FieldTypes = []string{"string", "int", "float", "bool"}
+	}
+	if options.Distribution == "" {
+		options.Distribution = Fixed
+	}
+
+	g := &Generator{options: options}
+	g.schema = g.buildSchema(rand.New(rand.NewSource(options.Seed)), "", options.Depth)
+	return g
+}
+
+func (g *Generator) buildSchema(r *rand.Rand, prefix string, depth int) (fields []field) {
+	for i := 0; i < g.options.Fields; i++ {
+		kind := g.options.FieldTypes[r.Intn(len(g.options.FieldTypes))]
+		if kind == "object" && depth <= 1 {
+			kind = "string"
+		}
+		f := field{name: fmt.Sprintf("%s%s_%d", prefix, kind, i), kind: kind}
+		if kind == "object" {
+			f.fields = g.buildSchema(r, "", depth-1)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// Key returns the key of the n-th document
+func (g *Generator) Key(n int) string {
+	return fmt.Sprintf(g.options.KeyPattern, n)
+}
+
+// Document returns the first version of the n-th document
+func (g *Generator) Document(n int) map[string]interface{} {
+	return g.DocumentVersion(n, 0)
+}
+
+// DocumentVersion returns the n-th document as it is after version updates
+func (g *Generator) DocumentVersion(n int, version int) map[string]interface{} {
+	r := rand.New(rand.NewSource(g.options.Seed ^ int64(n)<<20 ^ int64(version)))
+
+	doc := map[string]interface{}{
+		"key":     g.Key(n),
+		"seq":     n,
+		"version": version,
+	}
+	for k, v := range g.values(r, g.schema) {
+		doc[k] = v
+	}
+	g.pad(r, doc)
+	return doc
+}
+
+// Bytes returns the JSON encoding of DocumentVersion
+func (g *Generator) Bytes(n int, version int) []byte {
+	bytes, _ := json.Marshal(g.DocumentVersion(n, version))
+	return bytes
+}
+
+func (g *Generator) values(r *rand.Rand, fields []field) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, f := range fields {
+		switch f.kind {
+		case "string":
+			values[f.name] = sentence(r, 1+r.Intn(6))
+		case "int":
+			values[f.name] = r.Intn(1000000)
+		case "float":
+			values[f.name] = float64(r.Intn(1000000)) / 100
+		case "bool":
+			values[f.name] = r.Intn(2) == 1
+		case "date":
+			values[f.name] = time.Unix(1400000000+r.Int63n(300000000), 0).UTC().Format(time.RFC3339)
+		case "array":
+			var items []string
+			for i := r.Intn(5); i >= 0; i-- {
+				items = append(items, words[r.Intn(len(words))])
+			}
+			values[f.name] = items
+		case "object":
+			values[f.name] = g.values(r, f.fields)
+		}
+	}
+	return values
+}
+
+func sentence(r *rand.Rand, count int) string {
+	var parts []string
+	for i := 0; i < count; i++ {
+		parts = append(parts, words[r.Intn(len(words))])
+	}
+	return strings.Join(parts, " ")
+}
+
+// targetSize picks the size of a document from the distribution
+func (g *Generator) targetSize(r *rand.Rand) int {
+	min, max := g.options.MinSize, g.options.MaxSize
+	switch g.options.Distribution {
+	case Uniform:
+		if max > min {
+			return min + r.Intn(max-min+1)
+		}
+	case Normal:
+		mean := float64(min+max) / 2
+		size := int(r.NormFloat64()*float64(max-min)/6 + mean)
+		if size < min {
+			size = min
+		} else if size > max {
+			size = max
+		}
+		return size
+	}
+	return max
+}
+
+// pad adds a padding field so that the encoded document reaches the
+// target size. Documents that are already larger are left as they are.
+func (g *Generator) pad(r *rand.Rand, doc map[string]interface{}) {
+	target := g.targetSize(r)
+	if target == 0 {
+		return
+	}
+	bytes, _ := json.Marshal(doc)
+	missing := target - len(bytes) - len(`,"padding":""`)
+	if missing < 0 {
+		return
+	}
+	padding := make([]byte, missing)
+	for i := range padding {
+		padding[i] = 'a' + byte(r.Intn(26))
+	}
+	doc["padding"] = string(padding)
+}
@@ -0,0 +1,108 @@
+package docgen
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDocumentsAreDeterministic(t *testing.T) {
+	options := Options{Seed: 42, MinSize: 200, MaxSize: 400, Distribution: Uniform, Depth: 2,
+		FieldTypes: []string{"string", "int", "float", "bool", "date", "array", "object"}}
+	first, second := New(options), New(options)
+
+	for _, c := range []struct {
+		n       int
+		version int
+	}{
+		{0, 0},
+		{1, 0},
+		{1, 3},
+		{1000, 7},
+	} {
+		if a, b := first.Bytes(c.n, c.version), second.Bytes(c.n, c.version); !bytes.Equal(a, b) {
+			t.Errorf("document %d version %d differs between generators of one seed:\n%s\n%s", c.n, c.version, a, b)
+		}
+		if a, b := first.Bytes(c.n, c.version), first.Bytes(c.n, c.version+1); bytes.Equal(a, b) {
+			t.Errorf("document %d is the same in version %d and %d", c.n, c.version, c.version+1)
+		}
+	}
+
+	other := New(Options{Seed: 43, MinSize: 200, MaxSize: 400, Distribution: Uniform})
+	if bytes.Equal(first.Bytes(1, 0), other.Bytes(1, 0)) {
+		t.Errorf("seeds 42 and 43 give the same document")
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		n       int
+		key     string
+	}{
+		{"", 7, "key_7"},
+		{"doc::%06d", 42, "doc::000042"},
+	} {
+		if key := New(Options{KeyPattern: c.pattern}).Key(c.n); key != c.key {
+			t.Errorf("key %d of %q is %s, expected %s", c.n, c.pattern, key, c.key)
+		}
+	}
+}
+
+func TestDocumentSize(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		options Options
+		min     int
+		max     int
+	}{
+		{"fixed", Options{MaxSize: 512}, 512, 512},
+		{"fixed large", Options{MaxSize: 10000, Fields: 10}, 10000, 10000},
+		{"uniform", Options{MinSize: 300, MaxSize: 600, Distribution: Uniform}, 300, 600},
+		{"normal", Options{MinSize: 300, MaxSize: 600, Distribution: Normal}, 300, 600},
+	} {
+		g := New(c.options)
+		for n := 0; n < 100; n++ {
+			if size := len(g.Bytes(n, 0)); size < c.min || size > c.max {
+				t.Errorf("%s: document %d has %d bytes, expected %d to %d", c.name, n, size, c.min, c.max)
+			}
+		}
+	}
+}
+
+func TestDocumentLargerThanTarget(t *testing.T) {
+	g := New(Options{MaxSize: 10, Fields: 8})
+	doc := g.Document(0)
+	if _, padded := doc["padding"]; padded {
+		t.Errorf("document larger than max-size is padded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		options Options
+		fields  []string
+	}{
+		{"valid", Options{KeyPattern: "k%d", MinSize: 1, MaxSize: 2, Distribution: Normal, FieldTypes: []string{"date"}}, nil},
+		{"key pattern", Options{KeyPattern: "key"}, []string{"key-pattern"}},
+		{"sizes", Options{MinSize: -1, MaxSize: -2}, []string{"min-size", "max-size"}},
+		{"distribution", Options{Distribution: "poisson"}, []string{"distribution"}},
+		{"field types", Options{FieldTypes: []string{"int", "blob"}}, []string{"field-types[1]"}},
+	} {
+		problems := c.options.Validate()
+		var fields []string
+		for _, problem := range problems {
+			fields = append(fields, problem.Field)
+		}
+		if len(fields) != len(c.fields) {
+			t.Errorf("%s: problems %v, expected %v", c.name, fields, c.fields)
+			continue
+		}
+		for i := range fields {
+			if fields[i] != c.fields[i] {
+				t.Errorf("%s: problems %v, expected %v", c.name, fields, c.fields)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// encodedApiKey returns the api-key of the node as elastic search expects
+// it in the header. An id:key pair is encoded, anything else is taken as
+// already encoded.
+func (node *ESNode) encodedApiKey() string {
+	if node.ApiKey == "" || !strings.Contains(node.ApiKey, ":") {
+		return node.ApiKey
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(node.ApiKey))
+	logger.RegisterSecret(encoded)
+	return encoded
+}
+
+func (node *ESNode) scheme() string {
+	if node.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// restCredentials returns the basic auth of the REST calls, username and
+// password stand in when neither http-username nor api-key is set
+func (node *ESNode) restCredentials() (username string, password string) {
+	if node.HttpUserName == "" && node.ApiKey == "" {
+		return node.AdminUserName, node.AdminPassword
+	}
+	return node.HttpUserName, node.HttpPassword
+}
+
+// newHttpClient builds the client the REST calls to the node go through,
+// with its TLS settings and credentials
+func (node *ESNode) newHttpClient() (client *http.Client, err error) {
+	var base http.RoundTripper = http.DefaultTransport
+	if node.TLS {
+		tlsConfig, err := newTLSConfig(node.CAFile, "", "", node.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		base = newTLSTransport(tlsConfig)
+	}
+
+	username, password := node.restCredentials()
+	return &http.Client{
+		Transport: &authTransport{
+			base:     base,
+			username: username,
+			password: password,
+			apiKey:   node.encodedApiKey(),
+		},
+	}, nil
+}
+
+// validateESAuth checks the REST credentials and TLS settings of an es node
+func validateESAuth(problems *ValidationError, path string, node ESNode) {
+	if node.ApiKey != "" && node.HttpUserName != "" {
+		problems.add(path+".api-key", "can not be combined with http-username")
+	}
+	if (node.HttpUserName == "") != (node.HttpPassword == "") {
+		problems.add(path+".http-username", "http-username and http-password must be given together")
+	}
+	if !node.TLS {
+		if node.CAFile != "" {
+			problems.add(path+".ca-file", "needs tls")
+		}
+		if node.InsecureSkipVerify {
+			problems.add(path+".insecure-skip-verify", "needs tls")
+		}
+	} else if node.CAFile != "" {
+		if _, err := ioutil.ReadFile(node.CAFile); err != nil {
+			problems.add(path+".ca-file", "%v", err)
+		}
+	}
+}
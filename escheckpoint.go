@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/proxy"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	checkpointType = "couchbaseCheckpoint"
+	maxVBuckets    = 1024
+)
+
+// checkpointId is how XDCR names the checkpoint of a vbucket,
+// _local/<vbucket>-<bucket uuid> followed by the remote bucket
+var checkpointId = regexp.MustCompile(`^_local/([0-9]+)-`)
+
+// Checkpoint is a couchbaseCheckpoint document the connector keeps for a
+// vbucket. Seqno is the last sequence number that reached the index, UUID
+// the failover id of the vbucket it was taken on.
+type Checkpoint struct {
+	Id         string
+	VBucket    int
+	Seqno      uint64
+	UUID       uint64
+	BucketUUID uint64
+}
+
+// Checkpoints lists the checkpoint documents of index. Connectors for
+// typeless indexes keep their checkpoints outside of the index, so there
+// are none to list.
+func (node *ESNode) Checkpoints(index string) (checkpoints []Checkpoint, err error) {
+	v, err := node.Version()
+	if err != nil {
+		return nil, err
+	}
+	if v.Typeless() {
+		return nil, errors.New(fmt.Sprintf("Elastic search %s indexes hold no %s documents", v, checkpointType))
+	}
+
+	request := SearchRequest{Query: "_type:" + checkpointType}
+	err = node.ScrollAll(index, request, func(hit Hit) error {
+		checkpoint, err := decodeCheckpoint(hit.Id, hit.Source)
+		if err != nil {
+			logger.Printf(logger.ERR, "Skipping checkpoint of %s %v", index, err)
+			return nil
+		}
+		checkpoints = append(checkpoints, checkpoint)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byVBucket(checkpoints))
+	return checkpoints, nil
+}
+
+type byVBucket []Checkpoint
+
+func (c byVBucket) Len() int           { return len(c) }
+func (c byVBucket) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byVBucket) Less(i, j int) bool { return c[i].VBucket < c[j].VBucket }
+
+// decodeCheckpoint reads a checkpoint document, the proxy.Checkpoint XDCR
+// committed under doc. The vbucket is the one the id names. Fields XDCR
+// may add are ignored, a document without seqno and failoverID is an
+// error.
+func decodeCheckpoint(id string, source []byte) (checkpoint Checkpoint, err error) {
+	checkpoint.Id = id
+
+	match := checkpointId.FindStringSubmatch(id)
+	if match == nil {
+		return checkpoint, errors.New(fmt.Sprintf("Checkpoint %s is not named _local/<vbucket>-<bucket uuid>", id))
+	}
+	vbucket, err := strconv.Atoi(match[1])
+	if err != nil || vbucket >= maxVBuckets {
+		return checkpoint, errors.New(fmt.Sprintf("Checkpoint %s names no vbucket", id))
+	}
+	checkpoint.VBucket = vbucket
+
+	var document struct {
+		Doc *struct {
+			proxy.Checkpoint
+			Seqno      *uint64 `json:"seqno"`
+			FailoverID *uint64 `json:"failoverID"`
+		} `json:"doc"`
+	}
+	if err = json.Unmarshal(source, &document); err != nil {
+		return checkpoint, errors.New(fmt.Sprintf("Unable to read checkpoint %s %v", id, err))
+	}
+	if document.Doc == nil || document.Doc.Seqno == nil || document.Doc.FailoverID == nil {
+		return checkpoint, errors.New(fmt.Sprintf("Checkpoint %s has no doc with seqno and failoverID", id))
+	}
+	checkpoint.Seqno = *document.Doc.Seqno
+	checkpoint.UUID = *document.Doc.FailoverID
+	checkpoint.BucketUUID = document.Doc.BucketUUID
+	return checkpoint, nil
+}
+
+// VBucketLag is how far the checkpoint of a vbucket is behind its high
+// seqno. Missing is set when the vbucket has no checkpoint yet and Stale
+// when the checkpoint was taken on another vbucket uuid, after a failover.
+type VBucketLag struct {
+	VBucket    int
+	HighSeqno  uint64
+	Checkpoint uint64
+	Behind     uint64
+	Missing    bool
+	Stale      bool
+}
+
+// CheckpointLag sums up the vbuckets of a bucket against the checkpoints
+// of its index
+type CheckpointLag struct {
+	VBuckets []VBucketLag
+	Behind   uint64
+	Missing  int
+	Stale    int
+}
+
+// Converged tells whether every mutation of the bucket is checkpointed
+func (lag CheckpointLag) Converged() bool {
+	return lag.Behind == 0 && lag.Missing == 0
+}
+
+func (lag CheckpointLag) String() string {
+	return fmt.Sprintf("%d mutations behind over %d vbuckets, %d without checkpoint, %d stale",
+		lag.Behind, len(lag.VBuckets), lag.Missing, lag.Stale)
+}
+
+// CompareCheckpoints computes how far the checkpoints are behind the high
+// seqnos. A vbucket without mutations needs no checkpoint.
+func CompareCheckpoints(seqnos map[int]VBucketSeqno, checkpoints []Checkpoint) (lag CheckpointLag) {
+	latest := make(map[int]Checkpoint)
+	for _, checkpoint := range checkpoints {
+		if current, ok := latest[checkpoint.VBucket]; !ok || checkpoint.Seqno > current.Seqno {
+			latest[checkpoint.VBucket] = checkpoint
+		}
+	}
+
+	var vbuckets []int
+	for vb := range seqnos {
+		vbuckets = append(vbuckets, vb)
+	}
+	sort.Ints(vbuckets)
+
+	for _, vb := range vbuckets {
+		seqno := seqnos[vb]
+		vbLag := VBucketLag{VBucket: vb, HighSeqno: seqno.HighSeqno}
+		checkpoint, ok := latest[vb]
+		switch {
+		case !ok && seqno.HighSeqno > 0:
+			vbLag.Missing = true
+			vbLag.Behind = seqno.HighSeqno
+			lag.Missing++
+		case ok:
+			vbLag.Checkpoint = checkpoint.Seqno
+			if checkpoint.Seqno < seqno.HighSeqno {
+				vbLag.Behind = seqno.HighSeqno - checkpoint.Seqno
+			}
+			if checkpoint.UUID != 0 && seqno.UUID != 0 && checkpoint.UUID != seqno.UUID {
+				vbLag.Stale = true
+				lag.Stale++
+			}
+		}
+		lag.Behind += vbLag.Behind
+		lag.VBuckets = append(lag.VBuckets, vbLag)
+	}
+	return lag
+}
+
+// CheckpointLagOf compares the bucket cb is connected to with the
+// checkpoints of index
+func CheckpointLagOf(cb *CouchbaseNode, es *ESNode, index string) (lag CheckpointLag, err error) {
+	seqnos, err := cb.VBucketSeqnos()
+	if err != nil {
+		return lag, err
+	}
+	checkpoints, err := es.Checkpoints(index)
+	if err != nil {
+		return lag, err
+	}
+	return CompareCheckpoints(seqnos, checkpoints), nil
+}
+
+// WaitForCheckpoints polls the checkpoint lag of index until it converged
+// or timeout passed and returns the last lag
+func WaitForCheckpoints(cb *CouchbaseNode, es *ESNode, index string, timeout time.Duration) (lag CheckpointLag, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if lag, err = CheckpointLagOf(cb, es, index); err != nil {
+			return lag, err
+		}
+		if lag.Converged() || time.Now().After(deadline) {
+			return lag, nil
+		}
+		time.Sleep(countPollInterval)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCheckpoint(t *testing.T) {
+	for _, c := range []struct {
+		id         string
+		source     string
+		checkpoint Checkpoint
+		err        string
+	}{
+		{
+			"_local/12-3f2a9c/remote",
+			`{"doc":{"seqno":150,"failoverID":18446744073709551615,"commitopaque":7,"vbopaque":8,"bucketUUId":99}}`,
+			Checkpoint{Id: "_local/12-3f2a9c/remote", VBucket: 12, Seqno: 150, UUID: 18446744073709551615, BucketUUID: 99},
+			"",
+		},
+		{
+			"_local/0-1",
+			`{"doc":{"seqno":0,"failoverID":5}}`,
+			Checkpoint{Id: "_local/0-1", VBucket: 0, Seqno: 0, UUID: 5},
+			"",
+		},
+		{"_local/1023-1", `{"doc":{"seqno":1,"failoverID":1}}`, Checkpoint{Id: "_local/1023-1", VBucket: 1023, Seqno: 1, UUID: 1}, ""},
+		{"_local/1024-1", `{"doc":{"seqno":1,"failoverID":1}}`, Checkpoint{}, "names no vbucket"},
+		{"checkpoint/7", `{"doc":{"seqno":1,"failoverID":1}}`, Checkpoint{}, "is not named"},
+		{"_local/3f2a-12", `{"doc":{"seqno":1,"failoverID":1}}`, Checkpoint{}, "is not named"},
+		{"_local/12345678901234567890123-1", `{"doc":{"seqno":1,"failoverID":1}}`, Checkpoint{}, "names no vbucket"},
+		{"_local/1-1", `{"seqno":1,"failoverID":1}`, Checkpoint{}, "has no doc with seqno and failoverID"},
+		{"_local/1-1", `{"doc":{"seqno":1}}`, Checkpoint{}, "has no doc with seqno and failoverID"},
+		{"_local/1-1", `{"doc":{"failoverID":1}}`, Checkpoint{}, "has no doc with seqno and failoverID"},
+		{"_local/1-1", `{}`, Checkpoint{}, "has no doc"},
+		{"_local/1-1", `{"doc":{"seqno":1,"failoverID":1,"history":[]},"_rev":"1-a"}`,
+			Checkpoint{Id: "_local/1-1", VBucket: 1, Seqno: 1, UUID: 1}, ""},
+		{"_local/1-1", `{"doc":{"seqno":"1","failoverID":1}}`, Checkpoint{}, "Unable to read checkpoint"},
+		{"_local/1-1", `{"doc":{"seqno":-1,"failoverID":1}}`, Checkpoint{}, "Unable to read checkpoint"},
+		{"_local/1-1", `not json`, Checkpoint{}, "Unable to read checkpoint"},
+	} {
+		checkpoint, err := decodeCheckpoint(c.id, []byte(c.source))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s %s: error %v, expected %q", c.id, c.source, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", c.id, c.source, err)
+		} else if checkpoint != c.checkpoint {
+			t.Errorf("%s %s: %+v, expected %+v", c.id, c.source, checkpoint, c.checkpoint)
+		}
+	}
+}
+
+func TestCompareCheckpoints(t *testing.T) {
+	seqnos := map[int]VBucketSeqno{
+		0: {VBucket: 0, HighSeqno: 100, UUID: 11},
+		1: {VBucket: 1, HighSeqno: 50, UUID: 22},
+		2: {VBucket: 2, HighSeqno: 0, UUID: 33},
+		3: {VBucket: 3, HighSeqno: 10, UUID: 44},
+	}
+	for _, c := range []struct {
+		name        string
+		checkpoints []Checkpoint
+		vbuckets    []VBucketLag
+		behind      uint64
+		missing     int
+		stale       int
+		converged   bool
+	}{
+		{
+			"caught up",
+			[]Checkpoint{{VBucket: 0, Seqno: 100, UUID: 11}, {VBucket: 1, Seqno: 50, UUID: 22}, {VBucket: 3, Seqno: 10}},
+			[]VBucketLag{
+				{VBucket: 0, HighSeqno: 100, Checkpoint: 100},
+				{VBucket: 1, HighSeqno: 50, Checkpoint: 50},
+				{VBucket: 2},
+				{VBucket: 3, HighSeqno: 10, Checkpoint: 10},
+			},
+			0, 0, 0, true,
+		},
+		{
+			"behind, missing and stale",
+			[]Checkpoint{{VBucket: 0, Seqno: 60, UUID: 11}, {VBucket: 1, Seqno: 50, UUID: 99}},
+			[]VBucketLag{
+				{VBucket: 0, HighSeqno: 100, Checkpoint: 60, Behind: 40},
+				{VBucket: 1, HighSeqno: 50, Checkpoint: 50, Stale: true},
+				{VBucket: 2},
+				{VBucket: 3, HighSeqno: 10, Behind: 10, Missing: true},
+			},
+			50, 1, 1, false,
+		},
+		{
+			"latest checkpoint of a vbucket wins",
+			[]Checkpoint{{VBucket: 0, Seqno: 100}, {VBucket: 0, Seqno: 20}, {VBucket: 1, Seqno: 50}, {VBucket: 3, Seqno: 12}},
+			[]VBucketLag{
+				{VBucket: 0, HighSeqno: 100, Checkpoint: 100},
+				{VBucket: 1, HighSeqno: 50, Checkpoint: 50},
+				{VBucket: 2},
+				{VBucket: 3, HighSeqno: 10, Checkpoint: 12},
+			},
+			0, 0, 0, true,
+		},
+	} {
+		lag := CompareCheckpoints(seqnos, c.checkpoints)
+		if !reflect.DeepEqual(lag.VBuckets, c.vbuckets) {
+			t.Errorf("%s: vbuckets %+v, expected %+v", c.name, lag.VBuckets, c.vbuckets)
+		}
+		if lag.Behind != c.behind || lag.Missing != c.missing || lag.Stale != c.stale || lag.Converged() != c.converged {
+			t.Errorf("%s: %s, converged %v", c.name, lag, lag.Converged())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// esNodeRetryAfter is how long a node that could not be reached is passed
+// over before calls go to it again
+const esNodeRetryAfter = 5 * time.Second
+
+// ESCluster spreads elastic search calls over all its nodes in turn and
+// moves on to the next node when one can not be reached. Client returns an
+// ESNode whose calls go through the cluster, Node one that always calls
+// the given node.
+type ESCluster struct {
+	Nodes []*ESNode
+
+	mutex  sync.Mutex
+	next   int
+	down   map[*ESNode]time.Time
+	client *ESNode
+}
+
+// NewESCluster builds a cluster of initialized nodes
+func NewESCluster(nodes []*ESNode) (cluster *ESCluster, err error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("No elastic search node initialized")
+	}
+	cluster = &ESCluster{
+		Nodes: nodes,
+		down:  make(map[*ESNode]time.Time),
+	}
+
+	client := *nodes[0]
+	client.Client = &http.Client{Transport: cluster}
+	client.version = nil
+	client.cluster = cluster
+	cluster.client = &client
+	return cluster, nil
+}
+
+// ConnectES initializes the nodes of the config and builds a cluster of them
+func ConnectES(config *Config) (cluster *ESCluster, err error) {
+	var nodes []*ESNode
+	for index := range config.ESNodes {
+		node := &config.ESNodes[index]
+		if err = node.Init(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return NewESCluster(nodes)
+}
+
+// Client returns an ESNode that balances its calls over the cluster
+func (cluster *ESCluster) Client() *ESNode {
+	return cluster.client
+}
+
+// Node returns the node with ip, for calls that have to reach it
+func (cluster *ESCluster) Node(ip string) *ESNode {
+	for _, node := range cluster.Nodes {
+		if node.Ip == ip {
+			return node
+		}
+	}
+	return nil
+}
+
+// order returns the nodes to try a call on, starting with the next one in
+// turn and leaving the nodes that recently failed for last
+func (cluster *ESCluster) order() (nodes []*ESNode) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	start := cluster.next
+	cluster.next = (cluster.next + 1) % len(cluster.Nodes)
+
+	var failed []*ESNode
+	for i := range cluster.Nodes {
+		node := cluster.Nodes[(start+i)%len(cluster.Nodes)]
+		if since, ok := cluster.down[node]; ok && time.Since(since) < esNodeRetryAfter {
+			failed = append(failed, node)
+		} else {
+			nodes = append(nodes, node)
+		}
+	}
+	return append(nodes, failed...)
+}
+
+func (cluster *ESCluster) markDown(node *ESNode, down bool) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if down {
+		cluster.down[node] = time.Now()
+	} else {
+		delete(cluster.down, node)
+	}
+}
+
+// failOver tells whether req can be sent to the next node after err. Reads
+// can always be sent again, other requests only when they never reached
+// the node.
+func failOver(req *http.Request, err error) bool {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// RoundTrip sends req to the nodes of the cluster until one of them answers
+func (cluster *ESCluster) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	var last error
+	for _, node := range cluster.order() {
+		base, err := url.Parse(node.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		attempt := req.Clone(req.Context())
+		attempt.URL.Scheme = base.Scheme
+		attempt.URL.Host = base.Host
+		attempt.Host = base.Host
+		if req.Body != nil && req.GetBody != nil {
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		transport := http.DefaultTransport
+		if node.Client != nil && node.Client.Transport != nil {
+			transport = node.Client.Transport
+		}
+		if resp, err = transport.RoundTrip(attempt); err == nil {
+			cluster.markDown(node, false)
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		cluster.markDown(node, true)
+		if !failOver(req, err) {
+			return nil, err
+		}
+		logger.Printf(logger.INFO, "Elastic search node %s can not be reached, trying the next node %v", node.Ip, err)
+		last = err
+		if req.Body != nil && req.GetBody == nil {
+			//the body is gone and can not be sent again
+			return nil, err
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("No elastic search node can be reached for %s %s %v", req.Method, req.URL.Path, last))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Health states of an elastic search cluster or index
+const (
+	HealthGreen  = "green"
+	HealthYellow = "yellow"
+	HealthRed    = "red"
+)
+
+var healthRank = map[string]int{HealthRed: 0, HealthYellow: 1, HealthGreen: 2}
+
+const (
+	defaultHealthStatus  = HealthYellow
+	defaultHealthTimeout = 60 * time.Second
+
+	// longest wait_for_status handed to elastic search in one request
+	maxHealthRequestWait = 30 * time.Second
+)
+
+type ClusterHealth struct {
+	ClusterName         string `json:"cluster_name"`
+	Status              string `json:"status"`
+	TimedOut            bool   `json:"timed_out"`
+	NumberOfNodes       int    `json:"number_of_nodes"`
+	ActiveShards        int    `json:"active_shards"`
+	RelocatingShards    int    `json:"relocating_shards"`
+	InitializingShards  int    `json:"initializing_shards"`
+	UnassignedShards    int    `json:"unassigned_shards"`
+	ActivePrimaryShards int    `json:"active_primary_shards"`
+}
+
+// Health returns the health of index, or of the whole cluster when index
+// is empty
+func (node *ESNode) Health(index string) (health ClusterHealth, err error) {
+	return node.health(index, "", 0)
+}
+
+func (node *ESNode) health(index string, status string, wait time.Duration) (health ClusterHealth, err error) {
+	api := fmt.Sprintf("%s/_cluster/health", node.BaseURL)
+	if index != "" {
+		api += "/" + index
+	}
+	if status != "" {
+		api += fmt.Sprintf("?wait_for_status=%s&timeout=%ds", status, int(wait.Seconds()))
+	}
+
+	resp, err := node.Client.Get(api)
+	if err != nil {
+		return health, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return health, err
+	}
+	// a wait_for_status that timed out is answered with 408 and the health
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusRequestTimeout {
+		return health, errors.New(fmt.Sprintf("Got HTTP Response %v on getting health %s", resp.Status, body))
+	}
+	err = json.Unmarshal(body, &health)
+	return health, err
+}
+
+// WaitForHealth waits until index, or the cluster when index is empty,
+// reaches status or a better one and returns the last health seen
+func (node *ESNode) WaitForHealth(index string, status string, timeout time.Duration) (health ClusterHealth, err error) {
+	if _, ok := healthRank[status]; !ok {
+		return health, errors.New(fmt.Sprintf("Unknown health status %s", status))
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := deadline.Sub(time.Now())
+		if wait > maxHealthRequestWait {
+			wait = maxHealthRequestWait
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		health, err = node.health(index, status, wait)
+		if err == nil && healthRank[health.Status] >= healthRank[status] {
+			return health, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return health, err
+			}
+			name := index
+			if name == "" {
+				name = "cluster"
+			}
+			return health, errors.New(fmt.Sprintf("%s did not reach %s in %v, status %s with %d unassigned and %d initializing shards",
+				name, status, timeout, health.Status, health.UnassignedShards, health.InitializingShards))
+		}
+		if err != nil {
+			time.Sleep(time.Second)
+		}
+	}
+}
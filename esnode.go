@@ -6,26 +6,57 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 )
 
 const (
 	ShutDownRetries = 5
-	documentQuery   = "q=_type:couchbaseDocument"
+	documentType    = "couchbaseDocument"
+	documentQuery   = "q=_type:" + documentType
+
+	countPollInterval = 500 * time.Millisecond
 )
 
+// ESNode is an elastic search node. username and password log in over ssh
+// and to the connector, the REST calls authenticate with http-username and
+// http-password or with api-key, given as id:key or already encoded, and
+// with username and password when neither is set.
+// xdcr-encryption, full or half, encrypts the replication to the connector
+// whose certificate is in xdcr-certificate-file.
 type ESNode struct {
-	Ip            string `json:"ip"`
-	Port          string `json:"port"`
-	AdminUserName string `json:"username"`
-	AdminPassword string `json:"password"`
-	ConnectorPort string `json:"connector-port"`
-	Client        *http.Client
-	BaseURL       string
-	ESPort        string
+	Ip                  string `json:"ip"`
+	Port                string `json:"port"`
+	AdminUserName       string `json:"username"`
+	AdminPassword       string `json:"password"`
+	ConnectorPort       string `json:"connector-port"`
+	HttpUserName        string `json:"http-username"`
+	HttpPassword        string `json:"http-password"`
+	ApiKey              string `json:"api-key"`
+	TLS                 bool   `json:"tls"`
+	CAFile              string `json:"ca-file"`
+	InsecureSkipVerify  bool   `json:"insecure-skip-verify"`
+	XDCREncryption      string `json:"xdcr-encryption"`
+	XDCRCertificateFile string `json:"xdcr-certificate-file"`
+	Client              *http.Client
+	BaseURL             string
+	ESPort              string
+	ProxyAddr           string
+	version             *ESVersion
+	cluster             *ESCluster
+}
+
+// ReplicationHost is the host:port XDCR replicates to, the proxy in front
+// of the connector when one is running
+func (node *ESNode) ReplicationHost() string {
+	if node.ProxyAddr != "" {
+		return node.ProxyAddr
+	}
+	return joinHostPort(node.Ip, node.ConnectorPort)
 }
 
 func (node *ESNode) StartService() (err error) {
@@ -36,7 +67,7 @@ func (node *ESNode) StartService() (err error) {
 		},
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", node.Ip), config)
+	client, err := ssh.Dial("tcp", joinHostPort(node.Ip, "22"), config)
 	if err != nil {
 		return err
 	}
@@ -53,51 +84,137 @@ func (node *ESNode) StartService() (err error) {
 	var b bytes.Buffer
 	session.Stdout = &b
 	if err := session.Run(command); err != nil {
-		fmt.Printf("Failed to run command %s", command)
+		logger.Printf(logger.ERR, "Failed to run command %s", command)
 	}
-	fmt.Println(b.String())
+	logger.Printf(logger.DEBUG, "%s", b.String())
 	return nil
 }
 
-func (node *ESNode) Init() {
-	//userinfo := url.UserPassword(node.AdminUserName, node.AdminPassword)
+func (node *ESNode) Init() (err error) {
 	if node.Ip == "" || node.Port == "" {
-		fmt.Printf("IP and port of the es node are needed")
+		logger.Printf(logger.ERR, "IP and port of the es node are needed")
 		os.Exit(1)
 	}
 
 	url := &url.URL{
-		Scheme: "http",
-		Host:   node.Ip + ":" + node.Port,
+		Scheme: node.scheme(),
+		Host:   joinHostPort(node.Ip, node.Port),
 	}
 	node.BaseURL = url.String()
-	node.Client = &http.Client{}
-	return
+	if node.Client, err = node.newHttpClient(); err != nil {
+		return errors.New(fmt.Sprintf("Unable to set up the client of es node %s %v", node.Ip, err))
+	}
+	node.version = nil
+	return nil
 }
 
 func (node *ESNode) CreateIndex(index string) (err error) {
-	api := fmt.Sprintf("%s/%s", node.BaseURL, index)
+	return node.CreateIndexWithBody(index, nil)
+}
 
-	req, err := http.NewRequest("PUT", api, nil)
-	req.Header.Add("Accept", "application/json")
+// CreateIndexWithBody creates index with the settings and mappings of body
+func (node *ESNode) CreateIndexWithBody(index string, body map[string]interface{}) (err error) {
+	api := fmt.Sprintf("%s/%s", node.BaseURL, index)
+	if body != nil {
+		if api, err = node.withTypeName(api, body); err != nil {
+			return err
+		}
+	}
+	logger.Printf(logger.DEBUG, "api %s", api)
 
-    fmt.Printf("api %s", api)
-    fmt.Printf("%v",req)
-	resp, err := node.Client.Do(req)
+	var request interface{}
+	if body != nil {
+		request = body
+	}
+	if err = node.doJson("PUT", api, request, nil); err != nil {
+		logger.Printf(logger.ERR, "Unable to create Index: %v", err)
+		return err
+	}
+	return nil
+}
 
+// PutTemplate installs the index template name. The index pattern is
+// moved from template to index_patterns when the node expects it there.
+func (node *ESNode) PutTemplate(name string, template map[string]interface{}) (err error) {
+	v, err := node.Version()
 	if err != nil {
-		fmt.Printf("\n Unable to create Index: %v ", err)
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		fmt.Printf("Got HTTP response %v on creation", resp.Status)
+	api := fmt.Sprintf("%s/_template/%s", node.BaseURL, name)
+	if api, err = node.withTypeName(api, template); err != nil {
 		return err
 	}
 
+	if pattern, ok := template["template"]; ok && v.IndexPatterns() {
+		if _, ok := template["index_patterns"]; !ok {
+			converted := make(map[string]interface{})
+			for key, value := range template {
+				converted[key] = value
+			}
+			delete(converted, "template")
+			converted["index_patterns"] = []interface{}{pattern}
+			template = converted
+		}
+	}
+	return node.doJson("PUT", api, template, nil)
+}
+
+// DeleteTemplate removes the template name, a template that does not
+// exist is not an error
+func (node *ESNode) DeleteTemplate(name string) (err error) {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/_template/%s", node.BaseURL, name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := node.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return errors.New(fmt.Sprintf("Received a bad status %v", resp.Status))
+	}
 	return nil
 }
 
+// typelessMappingKeys are the keys of mappings without a type level
+var typelessMappingKeys = []string{"properties", "dynamic_templates", "dynamic", "_source", "_meta",
+	"_routing", "date_detection", "numeric_detection"}
+
+// withTypeName adds include_type_name to api when the mappings of body have
+// a type level and the node needs to be told about it
+func (node *ESNode) withTypeName(api string, body map[string]interface{}) (string, error) {
+	v, err := node.Version()
+	if err != nil {
+		return api, err
+	}
+	mappings, ok := body["mappings"].(map[string]interface{})
+	if !ok || len(mappings) == 0 || !v.IncludeTypeName() {
+		return api, nil
+	}
+	for _, key := range typelessMappingKeys {
+		if _, ok := mappings[key]; ok {
+			return api, nil
+		}
+	}
+	return api + "?include_type_name=true", nil
+}
+
+// GetMapping returns the mappings of index
+func (node *ESNode) GetMapping(index string) (mappings map[string]interface{}, err error) {
+	api := fmt.Sprintf("%s/%s/_mapping", node.BaseURL, index)
+	var response map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err = node.doJson("GET", api, nil, &response); err != nil {
+		return nil, err
+	}
+	for _, definition := range response {
+		return definition.Mappings, nil
+	}
+	return nil, errors.New(fmt.Sprintf("No mapping returned for %s", index))
+}
+
 func (node *ESNode) DeleteIndex(index string) (err error) {
 	api := fmt.Sprintf("%s/%s", node.BaseURL, index)
 
@@ -107,12 +224,12 @@ func (node *ESNode) DeleteIndex(index string) (err error) {
 	resp, err := node.Client.Do(req)
 
 	if err != nil {
-		fmt.Printf("\n Unable to delete Index: %v ", err)
+		logger.Printf(logger.ERR, "Unable to delete Index: %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Got HTTP response %v on creation", resp.Status)
+		logger.Printf(logger.ERR, "Got HTTP response %v on creation", resp.Status)
 		return err
 	}
 
@@ -121,174 +238,196 @@ func (node *ESNode) DeleteIndex(index string) (err error) {
 }
 
 func (node *ESNode) GetCount(index string) (repCount int, err error) {
-	query := "_type:couchbaseDocument"
+	v, err := node.Version()
+	if err != nil {
+		return 0, err
+	}
 
-	api := fmt.Sprintf("%s/%s/_count?q=%s", node.BaseURL, index, query)
+	//Make the latest writes visible to the count
+	if err = node.Refresh(index); err != nil {
+		logger.Printf(logger.DEBUG, "Unable to refresh %s %v", index, err)
+	}
+
+	//Typeless indexes only hold documents, checkpoints are kept elsewhere
+	api := fmt.Sprintf("%s/%s/_count", node.BaseURL, index)
+	if !v.Typeless() {
+		api += "?" + documentQuery
+	}
 
 	resp, err := node.Client.Get(api)
 	if err != nil {
-        return 0, err
+		return 0, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		err = errors.New(fmt.Sprintf("Got HTTP Response %v on getting count", resp.Status))
-        return 0, err 
+		return 0, err
 	}
 
 	respJson := make(map[string]interface{})
 
 	if body, err := ioutil.ReadAll(resp.Body); err == nil {
-		fmt.Printf("val %s", body)
+		logger.Printf(logger.DEBUG, "val %s", body)
 		err = json.Unmarshal(body, &respJson)
 		if err != nil {
-			fmt.Printf("Unable to parse the response JSON. Error %v", err)
-            return 0, err
+			logger.Printf(logger.ERR, "Unable to parse the response JSON. Error %v", err)
+			return 0, err
 		}
 	}
 
 	count, ok := (respJson["count"]).(float64)
 	if !ok {
 		err = errors.New(fmt.Sprintf("Unable to convert to int %v %v", ok, count))
-        return 0, err
+		return 0, err
 	}
 
 	return int(count), nil
 }
 
-func (node *ESNode) StopService() (err error) {
-	config := &ssh.ClientConfig{
-		User: node.AdminUserName,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(node.AdminPassword),
-		},
-	}
-
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", node.Ip), config)
+// MissingDocuments returns the ids that have no document in index
+func (node *ESNode) MissingDocuments(index string, ids []string) (missing []string, err error) {
+	const batchSize = 1000
+	v, err := node.Version()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	api := fmt.Sprintf("%s/%s/_mget", node.BaseURL, index)
+	if !v.Typeless() {
+		api = fmt.Sprintf("%s/%s/%s/_mget", node.BaseURL, index, documentType)
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
-	if err != nil {
-		return err
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		request, err := json.Marshal(map[string]interface{}{"ids": ids[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := node.Client.Post(api+"?_source=false", "application/json", bytes.NewReader(request))
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New(fmt.Sprintf("Got HTTP Response %v on getting documents %s", resp.Status, body))
+		}
+
+		var response struct {
+			Docs []struct {
+				Id    string `json:"_id"`
+				Found bool   `json:"found"`
+			} `json:"docs"`
+		}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return nil, err
+		}
+		for _, doc := range response.Docs {
+			if !doc.Found {
+				missing = append(missing, doc.Id)
+			}
+		}
 	}
-	defer session.Close()
+	return missing, nil
+}
 
-	command := "pkill -f elasticsearch"
+// Refresh makes every write to index so far visible to searches and counts
+func (node *ESNode) Refresh(index string) (err error) {
+	api := fmt.Sprintf("%s/%s/_refresh", node.BaseURL, index)
+	return node.doJson("POST", api, nil, nil)
+}
 
-	if err := session.Run(command); err != nil {
-		fmt.Printf("Failed to run command %s", command)
+// WaitForCount polls the count of index until it is expected or timeout
+// passed and returns the last count. Documents of index with an id in
+// excluded are left out of the count.
+func (node *ESNode) WaitForCount(index string, expected int, excluded []string, timeout time.Duration) (count int, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if count, err = node.GetCount(index); err != nil {
+			return count, err
+		}
+		if len(excluded) > 0 {
+			missing, err := node.MissingDocuments(index, excluded)
+			if err != nil {
+				return count, err
+			}
+			count -= len(excluded) - len(missing)
+		}
+		if count == expected || time.Now().After(deadline) {
+			return count, nil
+		}
+		time.Sleep(countPollInterval)
 	}
-	return nil
 }
 
-/*
-func (e *ESNode) GetCouchbaseCheckpointCount(index string) int {
-	api := path.Join(e.BaseURI, index, "couchbaseCheckpoint", "_count")
+func (node *ESNode) ListIndexes() (indexes []string, err error) {
+	api := fmt.Sprintf("%s/_aliases", node.BaseURL)
 
-	resp, err := e.Client.Get(api)
+	resp, err := node.Client.Get(api)
 	if err != nil {
-		fmt.Printf("Unable to get Couchbase checkpoint count")
-		os.Exit(1)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Got HTTp Response %v on getting couchbase checkpoint count", resp.Status)
-		os.Exit(1)
+		return nil, errors.New(fmt.Sprintf("Got HTTP Response %v on listing indexes", resp.Status))
 	}
-	var response map[string]interface{}
 
-	err = json.Unmarshal(resp.Body, response)
+	respJson := make(map[string]interface{})
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Unable to parse the response JSON")
-		os.Exit(1)
+		return nil, err
 	}
-
-	val := response["count"]
-	count, ok := val.(int)
-	if !ok {
-		fmt.Printf("Unable to convert to int")
-		count = 0
+	if err = json.Unmarshal(body, &respJson); err != nil {
+		return nil, err
 	}
-
-	return count
-
+	for index := range respJson {
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
 }
-/*
-func (e *ESNode) GetCouchbaseDocument(index string) int {
-	api := path.Join(e.BaseURI, index, "couchbaseDocument", "_count")
 
-	resp, err := e.Client.Get(api)
-	if err != nil {
-		fmt.Printf("Unable to get Couchbase document count")
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Got HTTP Response %v on getting couchbase document count", resp.Status)
-		os.Exit(1)
+func (node *ESNode) StopService() (err error) {
+	config := &ssh.ClientConfig{
+		User: node.AdminUserName,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(node.AdminPassword),
+		},
 	}
-	var response map[string]interface{}
 
-	err = json.Unmarshal(resp.Body, response)
+	client, err := ssh.Dial("tcp", joinHostPort(node.Ip, "22"), config)
 	if err != nil {
-		fmt.Printf("Unable to parse the response JSON")
-		os.Exit(1)
-	}
-
-	count, ok := response["count"].(int)
-	if !ok {
-		fmt.Printf("Unable to convert to int")
-		count = 0
+		return err
 	}
+	defer client.Close()
 
-	return count
-
-}
-*/
-
-func (node *ESNode) Search(index, query string) (count int, err error) {
-	values := url.Values{}
-	values.Set("pretty", "true")
-	values.Set("q", query)
-
-	api := fmt.Sprintf("%s/%s/%s", node.BaseURL, index, "_search?"+values.Encode())
-
-	req, err := http.NewRequest("GET", api, nil)
-	req.Header.Add("Accept", "application/json")
-
-	resp, err := node.Client.Do(req)
+	session, err := client.NewSession()
 	if err != nil {
-		fmt.Printf("\n Unable to search for the docs\n")
+		return err
 	}
+	defer session.Close()
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Got HTTP Response %v", resp.Status)
-		return 0, errors.New("HTTP error")
-	}
+	command := "pkill -f elasticsearch"
 
-	var response map[string]interface{}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("\n Error reading the response \n")
-		return 0, err
+	if err := session.Run(command); err != nil {
+		logger.Printf(logger.ERR, "Failed to run command %s", command)
 	}
+	return nil
+}
 
-	err = json.Unmarshal(body, response)
+// Search returns the number of documents in index matching the query
+// string query
+func (node *ESNode) Search(index, query string) (count int, err error) {
+	result, err := node.SearchDocuments(index, SearchRequest{Query: query, Size: 1})
 	if err != nil {
-		fmt.Printf("Unable to parse the response JSON")
+		logger.Printf(logger.ERR, "Unable to search for the docs %v", err)
 		return 0, err
 	}
-
-	count, ok := response["count"].(int)
-	if !ok {
-		count = 0
-		err = errors.New("Unable to convert to int")
-	}
-	return count, nil
+	return result.Total, nil
 }
 
 /*
@@ -298,7 +437,7 @@ func (node *ESNode) Shutdown() {
 		resp, err := node.Client.Post(api)
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Got HTTP response %v on trying to shut down a node")
+			logger.Printf(logger.INFO, "Got HTTP response %v on trying to shut down a node")
 		}
 	}
 }
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const defaultScroll = "1m"
+
+// SearchRequest is a query string search when Query is set and a query DSL
+// search with Body otherwise. Body is the JSON request body, for example
+// {"query": {"term": {"type": "json"}}, "aggs": {...}}. From and Size page
+// through the hits, Scroll keeps a scroll context open for that long
+// (1m, 30s) to fetch the rest with ScrollNext.
+type SearchRequest struct {
+	Query  string
+	Body   map[string]interface{}
+	From   int
+	Size   int
+	Scroll string
+}
+
+type Hit struct {
+	Index   string          `json:"_index"`
+	Type    string          `json:"_type"`
+	Id      string          `json:"_id"`
+	Version int64           `json:"_version"`
+	Score   *float64        `json:"_score"`
+	Source  json.RawMessage `json:"_source"`
+}
+
+type SearchResult struct {
+	Total        int
+	Hits         []Hit
+	Aggregations map[string]json.RawMessage
+	ScrollId     string
+}
+
+type searchResponse struct {
+	ScrollId string `json:"_scroll_id"`
+	Hits     struct {
+		Total json.RawMessage `json:"total"`
+		Hits  []Hit           `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+}
+
+// total reads hits.total, a number before elastic search 7 and an object
+// with a value since
+func (response *searchResponse) total() (total int, err error) {
+	raw := response.Hits.Total
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	if err = json.Unmarshal(raw, &total); err == nil {
+		return total, nil
+	}
+	var object struct {
+		Value int `json:"value"`
+	}
+	if err = json.Unmarshal(raw, &object); err != nil {
+		return 0, errors.New(fmt.Sprintf("Unable to read hits.total %s", raw))
+	}
+	return object.Value, nil
+}
+
+func (response *searchResponse) result() (result SearchResult, err error) {
+	if result.Total, err = response.total(); err != nil {
+		return result, err
+	}
+	result.Hits = response.Hits.Hits
+	result.Aggregations = response.Aggregations
+	result.ScrollId = response.ScrollId
+	return result, nil
+}
+
+// doJson sends body as JSON and decodes the response into v
+func (node *ESNode) doJson(method string, api string, body interface{}, v interface{}) (err error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, api, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := node.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Printf(logger.DEBUG, "%s %s returned %s", method, api, data)
+		return errors.New(fmt.Sprintf("Got HTTP Response %v %s", resp.Status, data))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SearchDocuments runs request against index and returns the total number
+// of hits, the page of hits asked for and the aggregations
+func (node *ESNode) SearchDocuments(index string, request SearchRequest) (result SearchResult, err error) {
+	v, err := node.Version()
+	if err != nil {
+		return result, err
+	}
+	values := url.Values{}
+	values.Set("version", "true")
+	if v.TrackTotalHits() {
+		values.Set("track_total_hits", "true")
+	}
+	if request.Query != "" {
+		values.Set("q", request.Query)
+	}
+	if request.From > 0 {
+		values.Set("from", strconv.Itoa(request.From))
+	}
+	if request.Size > 0 {
+		values.Set("size", strconv.Itoa(request.Size))
+	}
+	if request.Scroll != "" {
+		values.Set("scroll", request.Scroll)
+	}
+	api := fmt.Sprintf("%s/%s/_search?%s", node.BaseURL, index, values.Encode())
+
+	var body interface{}
+	method := "GET"
+	if request.Query == "" && request.Body != nil {
+		body = request.Body
+		method = "POST"
+	}
+
+	var response searchResponse
+	if err = node.doJson(method, api, body, &response); err != nil {
+		return result, err
+	}
+	return response.result()
+}
+
+// ScrollNext fetches the next page of a scroll and keeps it open for
+// scroll, an empty page means the scroll is done
+func (node *ESNode) ScrollNext(scrollId string, scroll string) (result SearchResult, err error) {
+	if scroll == "" {
+		scroll = defaultScroll
+	}
+	v, err := node.Version()
+	if err != nil {
+		return result, err
+	}
+	api := fmt.Sprintf("%s/_search/scroll", node.BaseURL)
+	var response searchResponse
+	if v.ScrollBody() {
+		err = node.doJson("POST", api, map[string]interface{}{"scroll": scroll, "scroll_id": scrollId}, &response)
+	} else {
+		values := url.Values{"scroll": {scroll}, "scroll_id": {scrollId}}
+		err = node.doJson("GET", api+"?"+values.Encode(), nil, &response)
+	}
+	if err != nil {
+		return result, err
+	}
+	return response.result()
+}
+
+// ClearScroll releases the scroll context
+func (node *ESNode) ClearScroll(scrollId string) (err error) {
+	v, err := node.Version()
+	if err != nil {
+		return err
+	}
+	api := fmt.Sprintf("%s/_search/scroll", node.BaseURL)
+	if !v.ScrollBody() {
+		return node.doJson("DELETE", api+"/"+url.PathEscape(scrollId), nil, nil)
+	}
+	return node.doJson("DELETE", api, map[string]interface{}{"scroll_id": []string{scrollId}}, nil)
+}
+
+// ScrollAll calls fn with every hit of request, fetched a page at a time
+// through a scroll. It stops at the first error fn returns.
+func (node *ESNode) ScrollAll(index string, request SearchRequest, fn func(hit Hit) error) (err error) {
+	if request.Scroll == "" {
+		request.Scroll = defaultScroll
+	}
+	if request.Size == 0 {
+		request.Size = 1000
+	}
+	result, err := node.SearchDocuments(index, request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if result.ScrollId != "" {
+			node.ClearScroll(result.ScrollId)
+		}
+	}()
+
+	for len(result.Hits) > 0 {
+		for _, hit := range result.Hits {
+			if err = fn(hit); err != nil {
+				return err
+			}
+		}
+		scrollId := result.ScrollId
+		if result, err = node.ScrollNext(scrollId, request.Scroll); err != nil {
+			result.ScrollId = scrollId
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ESVersion is the version an elastic search node reports on its root
+// endpoint. The request formats of ESNode follow it: document types and
+// _type queries before 7, typeless APIs and track_total_hits since.
+type ESVersion struct {
+	Number string
+	Major  int
+	Minor  int
+}
+
+func (v ESVersion) String() string {
+	return v.Number
+}
+
+// Less tells whether v is older than other
+func (v ESVersion) Less(other ESVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// Typeless tells whether indexes have no document types, so counts and
+// lookups can not be restricted to couchbaseDocument
+func (v ESVersion) Typeless() bool {
+	return v.Major >= 7
+}
+
+// TrackTotalHits tells whether hits.total has to be asked for to be exact
+func (v ESVersion) TrackTotalHits() bool {
+	return v.Major >= 7
+}
+
+// IncludeTypeName tells whether mappings with a type level have to be
+// flagged, elastic search 7 only takes them with include_type_name
+func (v ESVersion) IncludeTypeName() bool {
+	return v.Major == 7
+}
+
+// IndexPatterns tells whether templates match indexes with index_patterns
+// instead of template
+func (v ESVersion) IndexPatterns() bool {
+	return v.Major >= 6
+}
+
+// ScrollBody tells whether the scroll APIs take the scroll id in a JSON
+// body, 1.x takes it in the query string and path
+func (v ESVersion) ScrollBody() bool {
+	return v.Major >= 2
+}
+
+// ParseESVersion reads a version number such as 1.7.5 or 7.10.2-SNAPSHOT
+func ParseESVersion(number string) (v ESVersion, err error) {
+	v.Number = number
+	parts := strings.SplitN(number, ".", 3)
+	if len(parts) < 2 {
+		return v, errors.New(fmt.Sprintf("Unable to read elastic search version %q", number))
+	}
+	if v.Major, err = strconv.Atoi(parts[0]); err != nil {
+		return v, errors.New(fmt.Sprintf("Unable to read elastic search version %q", number))
+	}
+	if v.Minor, err = strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0]); err != nil {
+		return v, errors.New(fmt.Sprintf("Unable to read elastic search version %q", number))
+	}
+	return v, nil
+}
+
+// Version detects the version of the node from its root endpoint once. A
+// client of an ESCluster has the oldest version of the nodes that answer,
+// so its requests work on every node of a cluster in the middle of an
+// upgrade.
+func (node *ESNode) Version() (v ESVersion, err error) {
+	if node.version != nil {
+		return *node.version, nil
+	}
+
+	if node.cluster != nil {
+		found := false
+		for _, member := range node.cluster.Nodes {
+			memberVersion, err := member.Version()
+			if err != nil {
+				continue
+			}
+			if !found || memberVersion.Less(v) {
+				v = memberVersion
+			}
+			found = true
+		}
+		if !found {
+			return v, errors.New("Unable to detect the version of any elastic search node")
+		}
+	} else {
+		var root struct {
+			Version struct {
+				Number string `json:"number"`
+			} `json:"version"`
+		}
+		if err = node.doJson("GET", node.BaseURL+"/", nil, &root); err != nil {
+			return v, err
+		}
+		if v, err = ParseESVersion(root.Version.Number); err != nil {
+			return v, err
+		}
+	}
+	node.version = &v
+	return v, nil
+}
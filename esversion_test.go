@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestParseESVersion(t *testing.T) {
+	for _, c := range []struct {
+		number string
+		major  int
+		minor  int
+		err    bool
+	}{
+		{"1.7.5", 1, 7, false},
+		{"2.4.6", 2, 4, false},
+		{"6.8.23", 6, 8, false},
+		{"7.10.2-SNAPSHOT", 7, 10, false},
+		{"8.0", 8, 0, false},
+		{"5.0.0-alpha5", 5, 0, false},
+		{"7.0-rc1", 7, 0, false},
+		{"", 0, 0, true},
+		{"7", 0, 0, true},
+		{"x.1.0", 0, 0, true},
+		{"7.y.0", 0, 0, true},
+	} {
+		v, err := ParseESVersion(c.number)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: parsed as %d.%d, expected an error", c.number, v.Major, v.Minor)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.number, err)
+		} else if v.Major != c.major || v.Minor != c.minor || v.String() != c.number {
+			t.Errorf("%q: parsed as %d.%d %s, expected %d.%d", c.number, v.Major, v.Minor, v, c.major, c.minor)
+		}
+	}
+}
+
+func TestESVersionFormats(t *testing.T) {
+	for _, c := range []struct {
+		number          string
+		typeless        bool
+		includeTypeName bool
+		indexPatterns   bool
+		scrollBody      bool
+	}{
+		{"1.7.5", false, false, false, false},
+		{"2.4.6", false, false, false, true},
+		{"5.6.16", false, false, false, true},
+		{"6.8.23", false, false, true, true},
+		{"7.17.9", true, true, true, true},
+		{"8.11.1", true, false, true, true},
+	} {
+		v, err := ParseESVersion(c.number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Typeless() != c.typeless || v.TrackTotalHits() != c.typeless || v.IncludeTypeName() != c.includeTypeName ||
+			v.IndexPatterns() != c.indexPatterns || v.ScrollBody() != c.scrollBody {
+			t.Errorf("%s: typeless %v include_type_name %v index_patterns %v scroll body %v",
+				c.number, v.Typeless(), v.IncludeTypeName(), v.IndexPatterns(), v.ScrollBody())
+		}
+	}
+}
+
+func TestESVersionLess(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		less bool
+	}{
+		{"1.7.5", "2.0.0", true},
+		{"6.8.0", "6.10.0", true},
+		{"7.10.2", "7.9.3", false},
+		{"7.10.2", "7.10.0", false},
+		{"8.0.0", "7.17.0", false},
+	} {
+		a, _ := ParseESVersion(c.a)
+		b, _ := ParseESVersion(c.b)
+		if a.Less(b) != c.less {
+			t.Errorf("%s < %s is %v, expected %v", c.a, c.b, a.Less(b), c.less)
+		}
+	}
+}
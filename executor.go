@@ -8,4 +8,6 @@ type Executor interface {
     Setup(config *Config) error
     TearDown() error
     Run() (time.Duration)
+    // Result is nil when the replicated data matched after Run
+    Result() error
 }
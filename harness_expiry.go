@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/workload"
+	"time"
+)
+
+const defaultExpiryWait = 60
+
+// SetupExpiry sets the expiry pager of bucket on every node when the
+// workload writes expiring documents
+func SetupExpiry(config *Config, nodes []*CouchbaseNode, bucket string, log *logger.Logger) {
+	if config.Workload == nil || config.Workload.Expiry == nil || config.Workload.Expiry.PagerInterval == 0 {
+		return
+	}
+	for _, node := range nodes {
+		if err := node.SetExpiryPager(bucket, config.Workload.Expiry.PagerInterval); err != nil {
+			log.Printf(logger.ERR, "Unable to set the expiry pager on %s %v", node.Ip, err)
+		}
+	}
+}
+
+// VerifyExpiry waits for the expiring documents of data to expire, compacts
+// the bucket and checks what became of them in index. The outcome is
+// logged in any case and only fails the run when it differs from the
+// expectation of the workload.
+func VerifyExpiry(config *Config, data *DataSource, cb *CouchbaseNode, es *ESNode,
+	bucket string, index string, log *logger.Logger) (err error) {
+	keys, last := data.Expiring()
+	if len(keys) == 0 {
+		return nil
+	}
+	expiry := config.Workload.Expiry
+	expect := expiry.Expect
+	if expect == "" {
+		expect = workload.ExpectRemoved
+	}
+	wait := expiry.Wait
+	if wait == 0 {
+		wait = defaultExpiryWait
+	}
+
+	log.Printf(logger.INFO, "Waiting for %d documents to expire until %s", len(keys), last.Format(time.RFC3339))
+	time.Sleep(last.Sub(time.Now()))
+	if err = cb.CompactBucket(bucket); err != nil {
+		log.Printf(logger.INFO, "Unable to compact %s %v", bucket, err)
+	}
+
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+	var missing []string
+	for {
+		if missing, err = es.MissingDocuments(index, keys); err != nil {
+			return err
+		}
+		if expect == workload.ExpectRemoved && len(missing) == len(keys) {
+			break
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	removed := len(missing)
+	kept := len(keys) - removed
+	log.Printf(logger.INFO, "Expired %d documents: %d removed from %s, %d still indexed", len(keys), removed, index, kept)
+
+	switch {
+	case expect == workload.ExpectRemoved && kept > 0:
+		return errors.New(fmt.Sprintf("%d of %d expired documents still in %s", kept, len(keys), index))
+	case expect == workload.ExpectKept && removed > 0:
+		return errors.New(fmt.Sprintf("%d of %d expired documents removed from %s", removed, len(keys), index))
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const defaultTemplateName = "cbes-couchbase"
+
+// IndexOptions describe the indexes a run creates. settings-file holds a
+// create index body with settings, analyzers and mappings, shards, replicas
+// and refresh-interval override its settings. template-file is installed
+// as template-name before any index is created, matching only the indexes
+// of the harness, and removed again in TearDown.
+type IndexOptions struct {
+	Shards          int    `json:"shards"`
+	Replicas        *int   `json:"replicas"`
+	RefreshInterval string `json:"refresh-interval"`
+	SettingsFile    string `json:"settings-file"`
+	TemplateFile    string `json:"template-file"`
+	TemplateName    string `json:"template-name"`
+	VerifyMappings  bool   `json:"verify-mappings"`
+}
+
+func readJsonObject(path string) (object map[string]interface{}, err error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bytes, &object); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a JSON object %v", path, err))
+	}
+	return object, nil
+}
+
+func childObject(parent map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := parent[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := make(map[string]interface{})
+	parent[key] = child
+	return child
+}
+
+// body builds the create index request of the options
+func (options *IndexOptions) body() (body map[string]interface{}, err error) {
+	body = make(map[string]interface{})
+	if options.SettingsFile != "" {
+		if body, err = readJsonObject(options.SettingsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	settings := childObject(body, "settings")
+	if nested, ok := settings["index"].(map[string]interface{}); ok {
+		settings = nested
+	}
+	if options.Shards > 0 {
+		settings["number_of_shards"] = options.Shards
+	}
+	if options.Replicas != nil {
+		settings["number_of_replicas"] = *options.Replicas
+	}
+	if options.RefreshInterval != "" {
+		settings["refresh_interval"] = options.RefreshInterval
+	}
+	if len(settings) == 0 {
+		delete(body, "settings")
+	}
+	return body, nil
+}
+
+func (options *IndexOptions) templateName() string {
+	if options.TemplateName != "" {
+		return options.TemplateName
+	}
+	return defaultTemplateName
+}
+
+// indexPattern is the pattern of the template for index, the harness
+// names its indexes after IndexSeed apart from the passthrough index
+func indexPattern(index string) string {
+	if index == PassthroughIndex {
+		return PassthroughIndex
+	}
+	return IndexSeed + "-*"
+}
+
+// scopedTemplate returns a copy of template that only matches the indexes
+// like index
+func scopedTemplate(template map[string]interface{}, index string) map[string]interface{} {
+	scoped := make(map[string]interface{})
+	for key, value := range template {
+		scoped[key] = value
+	}
+	delete(scoped, "index_patterns")
+	scoped["template"] = indexPattern(index)
+	return scoped
+}
+
+func (options *IndexOptions) validate(problems *ValidationError) {
+	if options.Shards < 0 {
+		problems.add("elastic.index.shards", "must not be negative")
+	}
+	if options.Replicas != nil && *options.Replicas < 0 {
+		problems.add("elastic.index.replicas", "must not be negative")
+	}
+	if options.SettingsFile != "" {
+		if _, err := readJsonObject(options.SettingsFile); err != nil {
+			problems.add("elastic.index.settings-file", "%v", err)
+		}
+	}
+	if options.TemplateFile != "" {
+		if _, err := readJsonObject(options.TemplateFile); err != nil {
+			problems.add("elastic.index.template-file", "%v", err)
+		}
+	}
+	if options.VerifyMappings && options.SettingsFile == "" && options.TemplateFile == "" {
+		problems.add("elastic.index.verify-mappings", "needs a settings-file or template-file with mappings")
+	}
+}
+
+// SetupIndex installs the configured template, creates index with the
+// configured settings, waits for it to become healthy and verifies that its
+// mappings are the ones asked for
+func SetupIndex(config *Config, es *ESNode, index string, log *logger.Logger) (err error) {
+	var options IndexOptions
+	if config.Elastic != nil && config.Elastic.Index != nil {
+		options = *config.Elastic.Index
+	}
+
+	var template map[string]interface{}
+	if options.TemplateFile != "" {
+		if template, err = readJsonObject(options.TemplateFile); err != nil {
+			return err
+		}
+		if err = es.PutTemplate(options.templateName(), scopedTemplate(template, index)); err != nil {
+			return errors.New(fmt.Sprintf("Error installing template %s %v", options.templateName(), err))
+		}
+		log.Printf(logger.INFO, "Installed template %s", options.templateName())
+	}
+
+	body, err := options.body()
+	if err != nil {
+		return err
+	}
+	if err = es.CreateIndexWithBody(index, body); err != nil {
+		return errors.New(fmt.Sprintf("Error creating index %v", err))
+	}
+	log.Printf(logger.INFO, "Created index %s", index)
+
+	if err = config.WaitForHealth(es, index); err != nil {
+		return errors.New(fmt.Sprintf("Index %s is not ready %v", index, err))
+	}
+
+	if !options.VerifyMappings {
+		return nil
+	}
+	actual, err := es.GetMapping(index)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error reading mappings of %s %v", index, err))
+	}
+	var mismatches []string
+	if mappings, ok := template["mappings"].(map[string]interface{}); ok {
+		mismatches = append(mismatches, compareMappings("", mappings, actual, true)...)
+	}
+	if mappings, ok := body["mappings"].(map[string]interface{}); ok {
+		mismatches = append(mismatches, compareMappings("", mappings, actual, true)...)
+	}
+	if len(mismatches) > 0 {
+		for _, mismatch := range mismatches {
+			log.Printf(logger.ERR, "Mapping of %s: %s", index, mismatch)
+		}
+		return errors.New(fmt.Sprintf("%d mappings of %s differ from the configured ones", len(mismatches), index))
+	}
+	log.Printf(logger.INFO, "Mappings of %s verified", index)
+	return nil
+}
+
+// TearDownIndex removes the template SetupIndex installed
+func TearDownIndex(config *Config, es *ESNode, log *logger.Logger) (err error) {
+	if config.Elastic == nil || config.Elastic.Index == nil || config.Elastic.Index.TemplateFile == "" {
+		return nil
+	}
+	name := config.Elastic.Index.templateName()
+	if err = es.DeleteTemplate(name); err != nil {
+		return errors.New(fmt.Sprintf("Error deleting template %s %v", name, err))
+	}
+	log.Printf(logger.INFO, "Deleted template %s", name)
+	return nil
+}
+
+// mappingAttributes are compared for every configured field
+var mappingAttributes = []string{"type", "analyzer", "search_analyzer", "index"}
+
+// compareMappings returns how actual differs from the mappings expected.
+// At the top level expected holds mapping types, below it fields.
+// _default_ only shapes the types created later and is not compared.
+func compareMappings(path string, expected map[string]interface{}, actual map[string]interface{}, types bool) (mismatches []string) {
+	if types {
+		if _, ok := expected["properties"]; ok {
+			//mappings without a type level, as in elastic search 7
+			return compareMappings(path, expected, actual, false)
+		}
+		names := sortedKeys(expected)
+		for _, name := range names {
+			if name == "_default_" {
+				continue
+			}
+			definition, _ := expected[name].(map[string]interface{})
+			found, ok := actual[name].(map[string]interface{})
+			if _, typeless := actual["properties"]; !ok && typeless {
+				//a typeless index answers without the type name
+				found, ok = actual, true
+			}
+			if !ok {
+				mismatches = append(mismatches, fmt.Sprintf("type %s is missing", name))
+				continue
+			}
+			mismatches = append(mismatches, compareMappings(name, definition, found, false)...)
+		}
+		return mismatches
+	}
+
+	properties, _ := expected["properties"].(map[string]interface{})
+	actualProperties, _ := actual["properties"].(map[string]interface{})
+	for _, name := range sortedKeys(properties) {
+		field := strings.TrimPrefix(path+"."+name, ".")
+		want, _ := properties[name].(map[string]interface{})
+		got, ok := actualProperties[name].(map[string]interface{})
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("field %s is missing", field))
+			continue
+		}
+		for _, attribute := range mappingAttributes {
+			if value, ok := want[attribute]; ok && fmt.Sprint(value) != fmt.Sprint(got[attribute]) {
+				mismatches = append(mismatches, fmt.Sprintf("field %s has %s %v, expected %v",
+					field, attribute, got[attribute], value))
+			}
+		}
+		mismatches = append(mismatches, compareMappings(field, want, got, false)...)
+	}
+	return mismatches
+}
+
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
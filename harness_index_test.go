@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func jsonObject(t *testing.T, s string) map[string]interface{} {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &object); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return object
+}
+
+func TestCompareMappings(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		expected   string
+		actual     string
+		mismatches []string
+	}{
+		{
+			"typed mappings match",
+			`{"couchbaseDocument":{"properties":{"doc":{"properties":{"name":{"type":"string","index":"not_analyzed"}}}}}}`,
+			`{"couchbaseDocument":{"properties":{"doc":{"properties":{"name":{"type":"string","index":"not_analyzed"},"extra":{"type":"long"}}}}}}`,
+			nil,
+		},
+		{
+			"type missing",
+			`{"couchbaseDocument":{"properties":{"a":{"type":"long"}}}}`,
+			`{"other":{"properties":{"a":{"type":"long"}}}}`,
+			[]string{"type couchbaseDocument is missing"},
+		},
+		{
+			"_default_ is not compared",
+			`{"_default_":{"properties":{"meta":{"type":"object"}}}}`,
+			`{}`,
+			nil,
+		},
+		{
+			"field differs",
+			`{"couchbaseDocument":{"properties":{"doc":{"properties":{"name":{"type":"text","analyzer":"english"},"age":{"type":"integer"}}}}}}`,
+			`{"couchbaseDocument":{"properties":{"doc":{"properties":{"name":{"type":"text","analyzer":"standard"}}}}}}`,
+			[]string{"field couchbaseDocument.doc.age is missing",
+				"field couchbaseDocument.doc.name has analyzer standard, expected english"},
+		},
+		{
+			"typeless mappings",
+			`{"properties":{"doc":{"properties":{"n":{"type":"keyword"}}}}}`,
+			`{"properties":{"doc":{"properties":{"n":{"type":"text"}}}}}`,
+			[]string{"field doc.n has type text, expected keyword"},
+		},
+		{
+			"typed expectation on a typeless index",
+			`{"couchbaseDocument":{"properties":{"n":{"type":"keyword"}}}}`,
+			`{"properties":{"n":{"type":"keyword"}}}`,
+			nil,
+		},
+		{
+			"booleans compare by value",
+			`{"properties":{"n":{"type":"keyword","index":false}}}`,
+			`{"properties":{"n":{"type":"keyword","index":false}}}`,
+			nil,
+		},
+	} {
+		mismatches := compareMappings("", jsonObject(t, c.expected), jsonObject(t, c.actual), true)
+		if !reflect.DeepEqual(mismatches, c.mismatches) {
+			t.Errorf("%s: %q, expected %q", c.name, mismatches, c.mismatches)
+		}
+	}
+}
+
+func TestScopedTemplate(t *testing.T) {
+	template := jsonObject(t, `{"template":"*","index_patterns":["*"],"order":10}`)
+	for _, c := range []struct {
+		index   string
+		pattern string
+	}{
+		{"index-3-0", "index-*"},
+		{PassthroughIndex, PassthroughIndex},
+	} {
+		scoped := scopedTemplate(template, c.index)
+		if scoped["template"] != c.pattern || scoped["index_patterns"] != nil || scoped["order"] != float64(10) {
+			t.Errorf("%s: scoped to %v", c.index, scoped)
+		}
+	}
+	if template["template"] != "*" {
+		t.Errorf("the template file is changed")
+	}
+}
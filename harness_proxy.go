@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/proxy"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultProxyPort = 3912
+)
+
+// harnessProxyFields are set by the harness from the es node the proxy is
+// in front of. XDCR sends no credentials of its own, so the proxy can not
+// ask for users either.
+var harnessProxyFields = []string{"upstream", "upstream-username", "upstream-password", "users"}
+
+// ProxyOptions switches on an in-process proxy between the couchbase
+// cluster and the elastic search connector. Host is the address the
+// couchbase nodes reach this machine at. The config file sets faults and
+// timeouts, the upstream and its credentials come from the es node.
+type ProxyOptions struct {
+	Enabled       bool   `json:"enabled"`
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	AdminPort     int    `json:"admin-port"`
+	ConfigFile    string `json:"config"`
+	HttpTimeout   int    `json:"http-timeout"`
+	RetryInterval int    `json:"retry-interval"`
+	Retries       int    `json:"retries"`
+}
+
+// StartProxy starts a proxy in front of the connector of es and routes the
+// remote cluster reference through it. It returns nil when the proxy is
+// not enabled.
+func StartProxy(options *ProxyOptions, es *ESNode) (server *proxy.ProxyServer, err error) {
+	if options == nil || !options.Enabled {
+		return nil, nil
+	}
+	if options.Host == "" {
+		return nil, errors.New("proxy host is needed for the couchbase nodes to reach the proxy")
+	}
+	if err = checkProxyConfigFile(options.ConfigFile); err != nil {
+		return nil, err
+	}
+
+	port := options.Port
+	if port == 0 {
+		port = defaultProxyPort
+	}
+
+	server = &proxy.ProxyServer{
+		Port:             port,
+		AdminPort:        options.AdminPort,
+		Upstream:         joinHostPort(es.Ip, es.ConnectorPort),
+		UpstreamUser:     es.AdminUserName,
+		UpstreamPassword: es.AdminPassword,
+		ConfigFile:       options.ConfigFile,
+		HttpTimeout:      options.HttpTimeout,
+		RetryInterval:    options.RetryInterval,
+		Retries:          options.Retries,
+	}
+	if err = server.Listen(); err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			logger.Printf(logger.ERR, "Proxy server stopped %v", err)
+		}
+	}()
+
+	es.ProxyAddr = net.JoinHostPort(options.Host, strconv.Itoa(port))
+	logger.Printf(logger.INFO, "Started proxy on %s for %s", es.ProxyAddr, server.Upstream)
+	return server, nil
+}
+
+// checkProxyConfigFile returns an error if the proxy config file sets
+// fields the harness sets
+func checkProxyConfigFile(fileName string) (err error) {
+	if fileName == "" {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(bytes, &fields); err != nil {
+		return errors.New(fmt.Sprintf("Unable to read proxy config %s %v", fileName, err))
+	}
+	var set []string
+	for _, field := range harnessProxyFields {
+		if _, ok := fields[field]; ok {
+			set = append(set, field)
+		}
+	}
+	if len(set) > 0 {
+		return errors.New(fmt.Sprintf("Proxy config %s sets %s, the harness proxy takes them from the es node",
+			fileName, strings.Join(set, ", ")))
+	}
+	return nil
+}
+
+func (options *ProxyOptions) validate(problems *ValidationError) {
+	if !options.Enabled {
+		return
+	}
+	if options.Host == "" {
+		problems.add("proxy.host", "is required when the proxy is enabled")
+	}
+	if err := checkProxyConfigFile(options.ConfigFile); err != nil {
+		problems.add("proxy.config", "%v", err)
+	}
+}
+
+func StopProxy(server *proxy.ProxyServer, es *ESNode) (err error) {
+	if server == nil {
+		return nil
+	}
+	es.ProxyAddr = ""
+	if err = server.Shutdown(); err != nil {
+		logger.Printf(logger.ERR, "Error stopping the proxy %v", err)
+	}
+	return err
+}
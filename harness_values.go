@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of raw values
+const (
+	ValueJSON      = "json"
+	ValueBinary    = "binary"
+	ValueString    = "string"
+	ValueScalar    = "scalar"
+	ValueMalformed = "malformed"
+	ValueAppended  = "appended"
+	ValuePrepended = "prepended"
+)
+
+var valueTypes = []string{ValueJSON, ValueBinary, ValueString, ValueScalar, ValueMalformed, ValueAppended, ValuePrepended}
+
+// Outcomes a value type can be expected to have in the index
+const (
+	Indexed = "indexed"
+	Skipped = "skipped"
+)
+
+const defaultValueWait = 60
+
+// ValueOptions write count documents of every type with raw values after
+// the replication is verified, and report how many of each type reached
+// the index after wait seconds. expect maps a type to indexed or skipped
+// for the types whose outcome has to be checked.
+type ValueOptions struct {
+	Count  int               `json:"count"`
+	Types  []string          `json:"types"`
+	Wait   int               `json:"wait"`
+	Expect map[string]string `json:"expect"`
+}
+
+func (options *ValueOptions) validate(problems *ValidationError) {
+	if options.Count <= 0 {
+		problems.add("values.count", "must be greater than 0")
+	}
+	if options.Wait < 0 {
+		problems.add("values.wait", "must not be negative")
+	}
+	for index, t := range options.Types {
+		if !containsFold(valueTypes, t) {
+			problems.add(fmt.Sprintf("values.types[%d]", index), "unknown type %q, expected one of %s",
+				t, strings.Join(valueTypes, ", "))
+		}
+	}
+	for t, outcome := range options.Expect {
+		if !containsFold(valueTypes, t) {
+			problems.add("values.expect."+t, "unknown type, expected one of %s", strings.Join(valueTypes, ", "))
+		} else if outcome != Indexed && outcome != Skipped {
+			problems.add("values.expect."+t, "must be %s or %s, got %q", Indexed, Skipped, outcome)
+		}
+	}
+}
+
+// writeValue stores the n-th value of type t under key
+func writeValue(cb *CouchbaseNode, r *rand.Rand, t string, key string, n int) (err error) {
+	switch t {
+	case ValueJSON:
+		return cb.SetRaw(key, []byte(fmt.Sprintf(`{"type":"%s","seq":%d}`, t, n)))
+	case ValueBinary:
+		value := make([]byte, 16+r.Intn(240))
+		r.Read(value)
+		// make sure it is never valid UTF-8
+		value[0] = 0xff
+		return cb.SetRaw(key, value)
+	case ValueString:
+		return cb.SetRaw(key, []byte(fmt.Sprintf("plain text value %d", n)))
+	case ValueScalar:
+		return cb.SetRaw(key, []byte(fmt.Sprintf("%d", n)))
+	case ValueMalformed:
+		return cb.SetRaw(key, []byte(fmt.Sprintf(`{"type":"%s","seq":%d,`, t, n)))
+	case ValueAppended:
+		if err = cb.SetRaw(key, []byte(fmt.Sprintf(`{"type":"%s","seq":%d}`, t, n))); err != nil {
+			return err
+		}
+		return cb.AppendRaw(key, []byte(`{"appended":true}`))
+	case ValuePrepended:
+		if err = cb.SetRaw(key, []byte(fmt.Sprintf(`{"type":"%s","seq":%d}`, t, n))); err != nil {
+			return err
+		}
+		return cb.PrependRaw(key, []byte("prefix"))
+	}
+	return errors.New(fmt.Sprintf("Unknown value type %s", t))
+}
+
+// VerifyValues writes the raw values into the bucket cb is connected to and
+// reports per type how many of them were indexed
+func VerifyValues(config *Config, cb *CouchbaseNode, es *ESNode, index string, log *logger.Logger) (err error) {
+	options := config.Values
+	if options == nil {
+		return nil
+	}
+	types := options.Types
+	if len(types) == 0 {
+		types = valueTypes
+	}
+	wait := options.Wait
+	if wait == 0 {
+		wait = defaultValueWait
+	}
+
+	expect := make(map[string]string)
+	for t, outcome := range options.Expect {
+		expect[strings.ToLower(t)] = outcome
+	}
+
+	r := rand.New(rand.NewSource(int64(config.runId)))
+	keys := make(map[string][]string)
+	for _, t := range types {
+		t = strings.ToLower(t)
+		for n := 0; n < options.Count; n++ {
+			key := fmt.Sprintf("%s-value-%d", t, n)
+			if err = writeValue(cb, r, t, key, n); err != nil {
+				return errors.New(fmt.Sprintf("Unable to write %s value %s %v", t, key, err))
+			}
+			keys[t] = append(keys[t], key)
+		}
+	}
+	log.Printf(logger.INFO, "Wrote %d raw values of each of %s, waiting %ds", options.Count, strings.Join(types, ", "), wait)
+	time.Sleep(time.Duration(wait) * time.Second)
+
+	var names []string
+	for t := range keys {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	log.Printf(logger.INFO, "%-10s %8s %8s %s", "type", "written", "indexed", "result")
+	for _, t := range names {
+		missing, err := es.MissingDocuments(index, keys[t])
+		if err != nil {
+			return err
+		}
+		indexed := len(keys[t]) - len(missing)
+
+		outcome := "mixed"
+		if indexed == len(keys[t]) {
+			outcome = Indexed
+		} else if indexed == 0 {
+			outcome = Skipped
+		}
+		result := outcome
+		if expected, ok := expect[t]; ok && expected != outcome {
+			result = fmt.Sprintf("%s, expected %s", outcome, expected)
+			failures = append(failures, fmt.Sprintf("%s %s", t, result))
+		}
+		log.Printf(logger.INFO, "%-10s %8d %8d %s", t, len(keys[t]), indexed, result)
+	}
+
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("raw values: %s", strings.Join(failures, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/loader"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/workload"
+	"time"
+)
+
+// bucketStore runs the workload against the connected bucket of a node
+type bucketStore struct {
+	node *CouchbaseNode
+}
+
+func (s bucketStore) Set(key string, doc interface{}) error {
+	return s.node.DoOp("SET", key, doc)
+}
+
+func (s bucketStore) SetWithExpiry(key string, expiry int, doc interface{}) error {
+	return s.node.SetWithExpiry(key, expiry, doc)
+}
+
+func (s bucketStore) Get(key string) error {
+	return s.node.DoOp("GET", key, nil)
+}
+
+func (s bucketStore) Delete(key string) error {
+	return s.node.DoOp("DELETE", key, nil)
+}
+
+// DataSource writes the data of a run into the bucket a node is connected
+// to: the dataset of the replication when it has one and the generated
+// workload otherwise
+type DataSource struct {
+	workload *workload.Engine
+	dataset  *loader.Options
+	store    bucketStore
+	log      *logger.Logger
+	done     chan bool
+	loaded   loader.Result
+	err      error
+}
+
+// NewDataSource creates the data source of the index-th replication
+func NewDataSource(config *Config, index int, node *CouchbaseNode, log *logger.Logger) *DataSource {
+	d := &DataSource{store: bucketStore{node}}
+	if dataset := config.Replications[index].Dataset; dataset != nil {
+		d.dataset = dataset
+		d.log = log.WithPrefix("loader")
+		return d
+	}
+
+	var options workload.Options
+	if config.Workload != nil {
+		options = *config.Workload
+	}
+	if config.action != nil && config.action.Mix != nil {
+		options.Mix = config.action.Mix
+	}
+	d.workload = workload.New(d.store, config.Generator(index), options, log.WithPrefix("workload"))
+	return d
+}
+
+// Start writes in the background until Stop
+func (d *DataSource) Start() {
+	if d.workload != nil {
+		d.workload.Start()
+		return
+	}
+	d.done = make(chan bool)
+	go func() {
+		d.loaded, d.err = loader.Load(d.store, *d.dataset, d.log)
+		close(d.done)
+	}()
+}
+
+// Stop stops the workload, a dataset is always loaded completely
+func (d *DataSource) Stop() {
+	if d.workload != nil {
+		d.workload.Stop()
+		return
+	}
+	<-d.done
+}
+
+// Run writes count operations of the workload or the whole dataset
+func (d *DataSource) Run(count int) {
+	if d.workload != nil {
+		d.workload.Run(count)
+		return
+	}
+	d.Start()
+	d.Stop()
+}
+
+func (d *DataSource) Err() error {
+	if d.workload != nil {
+		return d.workload.Err()
+	}
+	return d.err
+}
+
+// Expected returns the number of documents that should be replicated,
+// VerifyExpiry checks the expiring ones afterwards
+func (d *DataSource) Expected() int {
+	if d.workload != nil {
+		return d.workload.Expected()
+	}
+	return len(d.loaded.Keys)
+}
+
+// Expiring returns the keys written with an expiry and when the last of
+// them expires
+func (d *DataSource) Expiring() (keys []string, last time.Time) {
+	if d.workload == nil {
+		return nil, last
+	}
+	return d.workload.Expiring()
+}
+
+// Uncounted returns the keys left out of Expected that may still be
+// indexed, the expiring documents and the ones whose last write failed
+func (d *DataSource) Uncounted() (keys []string) {
+	if d.workload == nil {
+		return nil
+	}
+	keys, _ = d.workload.Expiring()
+	return append(keys, d.workload.Unknown()...)
+}
+
+// VerifyKeys checks that every key of a dataset has a document in index
+func (d *DataSource) VerifyKeys(es *ESNode, index string) (err error) {
+	if d.workload != nil || len(d.loaded.Keys) == 0 {
+		return nil
+	}
+	missing, err := es.MissingDocuments(index, d.loaded.Keys)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("%d of %d keys missing in %s, first %s",
+			len(missing), len(d.loaded.Keys), index, missing[0]))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// authTransport adds credentials to every request, an api key when one is
+// set and basic auth otherwise
+type authTransport struct {
+	base     http.RoundTripper
+	username string
+	password string
+	apiKey   string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.username == "" && t.apiKey == "" {
+		return t.base.RoundTrip(req)
+	}
+	authorized := req.Clone(req.Context())
+	if t.apiKey != "" {
+		authorized.Header.Set("Authorization", "ApiKey "+t.apiKey)
+	} else {
+		authorized.SetBasicAuth(t.username, t.password)
+	}
+	return t.base.RoundTrip(authorized)
+}
+
+// newTLSConfig verifies servers with the certificates in caFile, or the
+// system ones when it is empty, and presents the client certificate of
+// certFile and keyFile when they are set
+func newTLSConfig(caFile, certFile, keyFile string, insecure bool) (config *tls.Config, err error) {
+	config = &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in %s", caFile))
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to load client certificate %s %v", certFile, err))
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+func newTLSTransport(config *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: config,
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Roles a couchbase node can have in an inventory
+const (
+	RoleInitial  = "initial"
+	RoleSpare    = "spare"
+	RoleRemove   = "remove"
+	RoleFailover = "failover"
+)
+
+var knownRoles = []string{RoleInitial, RoleSpare, RoleRemove, RoleFailover}
+
+var knownServices = []string{"kv", "index", "n1ql", "fts"}
+
+// Inventory describes the nodes of a lab. The ssh settings apply to every
+// couchbase node that does not set its own.
+type Inventory struct {
+	SSHUserName string          `json:"ssh-username"`
+	SSHPassword string          `json:"ssh-password"`
+	SSHPort     string          `json:"ssh-port"`
+	SSHKeyFile  string          `json:"ssh-key-file"`
+	CBNodes     []CouchbaseNode `json:"cb-nodes"`
+	ESNodes     []ESNode        `json:"es-nodes"`
+}
+
+// loadInventory reads the inventory file of the config into its node lists
+func loadInventory(config *Config) (err error) {
+	if config.Inventory == "" {
+		return nil
+	}
+	if len(config.CBNodes) > 0 || len(config.ESNodes) > 0 {
+		return ValidationError{{"inventory", "can not be combined with cb-nodes or es-nodes"}}
+	}
+
+	bytes, err := ioutil.ReadFile(config.Inventory)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error reading inventory %v", err))
+	}
+	var inventory Inventory
+	if err = decodeStrict(bytes, &inventory, config.Inventory); err != nil {
+		return err
+	}
+
+	for index := range inventory.CBNodes {
+		node := &inventory.CBNodes[index]
+		if node.SSHUserName == "" {
+			node.SSHUserName = inventory.SSHUserName
+		}
+		if node.SSHPassword == "" && node.SSHKeyFile == "" {
+			node.SSHPassword = inventory.SSHPassword
+			node.SSHKeyFile = inventory.SSHKeyFile
+		}
+		if node.SSHPort == "" {
+			node.SSHPort = inventory.SSHPort
+		}
+	}
+	config.CBNodes = inventory.CBNodes
+	config.ESNodes = inventory.ESNodes
+	return nil
+}
+
+func (node *CouchbaseNode) HasRole(role string) bool {
+	for _, r := range node.Roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Topology is the part of the cluster a situation works with. Endpoint is
+// an initial node that stays in the cluster and drives the others.
+type Topology struct {
+	Endpoint *CouchbaseNode
+	Initial  []*CouchbaseNode
+	Add      []*CouchbaseNode
+	Remove   []*CouchbaseNode
+	Failover []*CouchbaseNode
+}
+
+// Others returns the initial nodes apart from the endpoint
+func (t *Topology) Others() (nodes []*CouchbaseNode) {
+	for _, node := range t.Initial {
+		if node != t.Endpoint {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// SelectNodes picks the nodes for situation by role. Nodes with the remove
+// or failover role are initial members as well. Without any roles the
+// first node is the endpoint, the last add-count nodes are spares and the
+// nodes to remove or fail over are taken from the end of the rest.
+func SelectNodes(nodes []CouchbaseNode, situation Situation) (t Topology, err error) {
+	withRoles := false
+	for index := range nodes {
+		if len(nodes[index].Roles) > 0 {
+			withRoles = true
+		}
+	}
+
+	var spare, remove, failover []*CouchbaseNode
+	if withRoles {
+		for index := range nodes {
+			node := &nodes[index]
+			if node.HasRole(RoleSpare) {
+				spare = append(spare, node)
+				continue
+			}
+			if !node.HasRole(RoleInitial) && !node.HasRole(RoleRemove) && !node.HasRole(RoleFailover) {
+				continue
+			}
+			t.Initial = append(t.Initial, node)
+			if node.HasRole(RoleRemove) {
+				remove = append(remove, node)
+			} else if node.HasRole(RoleFailover) {
+				failover = append(failover, node)
+			} else if t.Endpoint == nil {
+				t.Endpoint = node
+			}
+		}
+	} else {
+		members := len(nodes) - situation.AddCount
+		if members < 1 {
+			members = 1
+		}
+		for index := range nodes {
+			if index < members {
+				t.Initial = append(t.Initial, &nodes[index])
+			} else {
+				spare = append(spare, &nodes[index])
+			}
+		}
+		if len(t.Initial) > 0 {
+			t.Endpoint = t.Initial[0]
+			for index := len(t.Initial) - 1; index > 0; index-- {
+				if len(remove) < situation.RemoveCount {
+					remove = append(remove, t.Initial[index])
+				} else if len(failover) < situation.FailoverCount {
+					failover = append(failover, t.Initial[index])
+				}
+			}
+		}
+	}
+
+	if t.Endpoint == nil {
+		return t, errors.New(fmt.Sprintf("situation %s needs an initial node that is not removed or failed over", situation.Id))
+	}
+
+	var problems []string
+	pick := func(role string, candidates []*CouchbaseNode, count int) []*CouchbaseNode {
+		if len(candidates) < count {
+			problems = append(problems, fmt.Sprintf("%d %s nodes, %d available", count, role, len(candidates)))
+			return candidates
+		}
+		return candidates[:count]
+	}
+	t.Add = pick(RoleSpare, spare, situation.AddCount)
+	t.Remove = pick(RoleRemove, remove, situation.RemoveCount)
+	t.Failover = pick(RoleFailover, failover, situation.FailoverCount)
+	if len(problems) > 0 {
+		return t, errors.New(fmt.Sprintf("situation %s needs %s", situation.Id, strings.Join(problems, ", ")))
+	}
+	return t, nil
+}
+
+// validateInventory checks the roles and services of the couchbase nodes.
+// Once one node has roles every node needs them.
+func validateInventory(problems *ValidationError, config *Config) {
+	withRoles := false
+	for _, node := range config.CBNodes {
+		if len(node.Roles) > 0 {
+			withRoles = true
+		}
+	}
+	for index, node := range config.CBNodes {
+		path := fmt.Sprintf("cb-nodes[%d]", index)
+		if withRoles && len(node.Roles) == 0 {
+			problems.add(path+".roles", "are required when other nodes have roles")
+		}
+		for i, role := range node.Roles {
+			if !containsFold(knownRoles, role) {
+				problems.add(fmt.Sprintf("%s.roles[%d]", path, i), "unknown role %q, expected one of %s",
+					role, strings.Join(knownRoles, ", "))
+			}
+		}
+		if node.HasRole(RoleSpare) && len(node.Roles) > 1 {
+			problems.add(path+".roles", "a spare node can not have other roles")
+		}
+		if node.HasRole(RoleRemove) && node.HasRole(RoleFailover) {
+			problems.add(path+".roles", "a node can not be both removed and failed over")
+		}
+		for i, service := range node.Services {
+			if !containsFold(knownServices, service) {
+				problems.add(fmt.Sprintf("%s.services[%d]", path, i), "unknown service %q, expected one of %s",
+					service, strings.Join(knownServices, ", "))
+			}
+		}
+	}
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
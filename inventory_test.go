@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectNodes(t *testing.T) {
+	node := func(ip string, roles ...string) CouchbaseNode {
+		return CouchbaseNode{Ip: ip, Roles: roles}
+	}
+	ips := func(nodes []*CouchbaseNode) (ips []string) {
+		for _, node := range nodes {
+			ips = append(ips, node.Ip)
+		}
+		return ips
+	}
+
+	for _, c := range []struct {
+		name      string
+		nodes     []CouchbaseNode
+		situation Situation
+		endpoint  string
+		initial   []string
+		add       []string
+		remove    []string
+		failover  []string
+		err       string
+	}{
+		{"without roles", []CouchbaseNode{node("a"), node("b"), node("c"), node("d")},
+			Situation{Id: "AddRb", AddCount: 2},
+			"a", []string{"a", "b"}, []string{"c", "d"}, nil, nil, ""},
+		{"without roles remove and fail over from the end", []CouchbaseNode{node("a"), node("b"), node("c")},
+			Situation{Id: "RemoveFo", RemoveCount: 1, FailoverCount: 1},
+			"a", []string{"a", "b", "c"}, nil, []string{"c"}, []string{"b"}, ""},
+		{"without roles too few spares", []CouchbaseNode{node("a"), node("b")},
+			Situation{Id: "AddRb", AddCount: 2},
+			"", nil, nil, nil, nil, "situation AddRb needs 2 spare nodes, 1 available"},
+		{"with roles", []CouchbaseNode{node("a", RoleRemove), node("b", RoleInitial), node("c", RoleSpare),
+			node("d", RoleFailover)},
+			Situation{Id: "Mixed", AddCount: 1, RemoveCount: 1, FailoverCount: 1},
+			"b", []string{"a", "b", "d"}, []string{"c"}, []string{"a"}, []string{"d"}, ""},
+		{"a node without roles is left out", []CouchbaseNode{node("a"), node("b", RoleInitial), node("c", RoleSpare)},
+			Situation{Id: "AddRb", AddCount: 1},
+			"b", []string{"b"}, []string{"c"}, nil, nil, ""},
+		{"no endpoint", []CouchbaseNode{node("a", RoleRemove), node("b", RoleSpare)},
+			Situation{Id: "RemoveRb", RemoveCount: 1},
+			"", nil, nil, nil, nil, "situation RemoveRb needs an initial node"},
+		{"no endpoint without initial roles", []CouchbaseNode{node("a"), node("b", RoleFailover)},
+			Situation{Id: "Fo", FailoverCount: 1},
+			"", nil, nil, nil, nil, "situation Fo needs an initial node"},
+		{"too few nodes with roles", []CouchbaseNode{node("a", RoleInitial), node("b", RoleRemove)},
+			Situation{Id: "RemoveRb", RemoveCount: 2, AddCount: 1},
+			"", nil, nil, nil, nil, "needs 1 spare nodes, 0 available, 2 remove nodes, 1 available"},
+	} {
+		topology, err := SelectNodes(c.nodes, c.situation)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, expected %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if topology.Endpoint.Ip != c.endpoint {
+			t.Errorf("%s: endpoint %s, expected %s", c.name, topology.Endpoint.Ip, c.endpoint)
+		}
+		for _, nodes := range []struct {
+			role     string
+			actual   []string
+			expected []string
+		}{
+			{RoleInitial, ips(topology.Initial), c.initial},
+			{RoleSpare, ips(topology.Add), c.add},
+			{RoleRemove, ips(topology.Remove), c.remove},
+			{RoleFailover, ips(topology.Failover), c.failover},
+		} {
+			if !reflect.DeepEqual(nodes.actual, nodes.expected) {
+				t.Errorf("%s: %s nodes %v, expected %v", c.name, nodes.role, nodes.actual, nodes.expected)
+			}
+		}
+	}
+}
+
+func TestValidateInventoryRoles(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		nodes    []CouchbaseNode
+		problems []string
+	}{
+		{"no roles", []CouchbaseNode{{}, {}}, nil},
+		{"roles on every node", []CouchbaseNode{{Roles: []string{RoleInitial}}, {Roles: []string{"Spare"}}}, nil},
+		{"roles on some nodes", []CouchbaseNode{{Roles: []string{RoleInitial}}, {}},
+			[]string{"cb-nodes[1].roles"}},
+		{"unknown role", []CouchbaseNode{{Roles: []string{"leader"}}},
+			[]string{"cb-nodes[0].roles[0]"}},
+		{"spare with other roles", []CouchbaseNode{{Roles: []string{RoleSpare, RoleInitial}}},
+			[]string{"cb-nodes[0].roles"}},
+	} {
+		var problems ValidationError
+		validateInventory(&problems, &Config{CBNodes: c.nodes})
+		var fields []string
+		for _, problem := range problems {
+			fields = append(fields, problem.Path)
+		}
+		if !reflect.DeepEqual(fields, c.problems) {
+			t.Errorf("%s: problems %v, expected %v", c.name, problems, c.problems)
+		}
+	}
+}
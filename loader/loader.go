@@ -0,0 +1,284 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeyField = "id"
+	DefaultWorkers  = 4
+
+	maxLineSize = 64 * 1024 * 1024
+)
+
+// Store is where the documents are loaded to
+type Store interface {
+	Set(key string, doc interface{}) error
+}
+
+// Options of a dataset. Path is a JSON Lines file or a directory whose
+// .jsonl and .json files are loaded in name order. A .json file holds one
+// document or an array of them. The key of a document is the value of its
+// key-field, keys-file records the loaded keys one per line.
+type Options struct {
+	Path      string `json:"path"`
+	KeyField  string `json:"key-field"`
+	Workers   int    `json:"workers"`
+	OpsPerSec int    `json:"ops-per-sec"`
+	KeysFile  string `json:"keys-file"`
+}
+
+// Problem is an invalid option, Field is its JSON name
+type Problem struct {
+	Field   string
+	Message string
+}
+
+// Validate returns every invalid option
+func (o *Options) Validate() (problems []Problem) {
+	if o.Path == "" {
+		problems = append(problems, Problem{"path", "is required"})
+	} else if _, err := os.Stat(o.Path); err != nil {
+		problems = append(problems, Problem{"path", err.Error()})
+	}
+	if o.Workers < 0 {
+		problems = append(problems, Problem{"workers", "must not be negative"})
+	}
+	if o.OpsPerSec < 0 {
+		problems = append(problems, Problem{"ops-per-sec", "must not be negative"})
+	}
+	return problems
+}
+
+// Result of a load. Keys holds every distinct key that was written.
+type Result struct {
+	Documents int
+	Keys      []string
+	Duration  time.Duration
+}
+
+type document struct {
+	key    string
+	source string
+	body   json.RawMessage
+}
+
+// Load writes every document of the dataset to store and returns the keys
+// written. It stops at the first document that can not be read or written.
+func Load(store Store, options Options, log *logger.Logger) (result Result, err error) {
+	if options.KeyField == "" {
+		options.KeyField = DefaultKeyField
+	}
+	if options.Workers == 0 {
+		options.Workers = DefaultWorkers
+	}
+	if log == nil {
+		log = logger.Default().WithPrefix("loader")
+	}
+
+	files, err := datasetFiles(options.Path)
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	docs := make(chan document)
+	stop := make(chan bool)
+	var once sync.Once
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			mu.Lock()
+			firstErr = err
+			mu.Unlock()
+			close(stop)
+		})
+	}
+
+	keys := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docs {
+				if err := store.Set(doc.key, doc.body); err != nil {
+					fail(errors.New(fmt.Sprintf("%s: unable to write %s %v", doc.source, doc.key, err)))
+					continue
+				}
+				mu.Lock()
+				keys[doc.key] = true
+				result.Documents++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var interval time.Duration
+	if options.OpsPerSec > 0 {
+		interval = time.Second / time.Duration(options.OpsPerSec)
+	}
+	next := time.Now()
+	emit := func(doc document) bool {
+		if interval > 0 {
+			time.Sleep(next.Sub(time.Now()))
+			next = next.Add(interval)
+		}
+		select {
+		case docs <- doc:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	for _, file := range files {
+		if err := readFile(file, options.KeyField, emit); err != nil {
+			fail(err)
+			break
+		}
+		log.Printf(logger.DEBUG, "Loaded %s", file)
+	}
+	close(docs)
+	wg.Wait()
+
+	for key := range keys {
+		result.Keys = append(result.Keys, key)
+	}
+	sort.Strings(result.Keys)
+	result.Duration = time.Since(start)
+	log.Printf(logger.INFO, "Loaded %d documents with %d distinct keys from %s in %v",
+		result.Documents, len(result.Keys), options.Path, result.Duration)
+
+	if firstErr != nil {
+		return result, firstErr
+	}
+	if options.KeysFile != "" {
+		if err = ioutil.WriteFile(options.KeysFile, []byte(strings.Join(result.Keys, "\n")+"\n"), 0644); err != nil {
+			return result, errors.New(fmt.Sprintf("Unable to write keys file %s %v", options.KeysFile, err))
+		}
+	}
+	return result, nil
+}
+
+func datasetFiles(path string) (files []string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".jsonl" || ext == ".json") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New(fmt.Sprintf("No .jsonl or .json files in %s", path))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readFile calls emit with every document of file until emit returns false
+func readFile(file string, keyField string, emit func(document) bool) (err error) {
+	if filepath.Ext(file) == ".json" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '[' {
+			var bodies []json.RawMessage
+			if err = json.Unmarshal(data, &bodies); err != nil {
+				return errors.New(fmt.Sprintf("%s: %v", file, err))
+			}
+			for index, body := range bodies {
+				doc, err := newDocument(fmt.Sprintf("%s[%d]", file, index), body, keyField)
+				if err != nil {
+					return err
+				}
+				if !emit(doc) {
+					return nil
+				}
+			}
+			return nil
+		}
+		doc, err := newDocument(file, data, keyField)
+		if err != nil {
+			return err
+		}
+		emit(doc)
+		return nil
+	}
+
+	fp, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		body := make(json.RawMessage, len(data))
+		copy(body, data)
+		doc, err := newDocument(fmt.Sprintf("%s:%d", file, line), body, keyField)
+		if err != nil {
+			return err
+		}
+		if !emit(doc) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func newDocument(source string, body json.RawMessage, keyField string) (doc document, err error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&fields); err != nil {
+		return doc, errors.New(fmt.Sprintf("%s: not a JSON object %v", source, err))
+	}
+	var key string
+	switch value := fields[keyField].(type) {
+	case string:
+		key = value
+	case json.Number:
+		key = value.String()
+	case nil:
+		return doc, errors.New(fmt.Sprintf("%s: missing key field %s", source, keyField))
+	default:
+		return doc, errors.New(fmt.Sprintf("%s: key field %s must be a string or a number", source, keyField))
+	}
+	if key == "" {
+		return doc, errors.New(fmt.Sprintf("%s: empty key field %s", source, keyField))
+	}
+	return document{key: key, source: source, body: body}, nil
+}
@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewDocument(t *testing.T) {
+	for _, c := range []struct {
+		body     string
+		keyField string
+		key      string
+		err      string
+	}{
+		{`{"id":"doc-1","name":"a"}`, "id", "doc-1", ""},
+		{`{"id":42}`, "id", "42", ""},
+		{`{"id":12345678901234567890}`, "id", "12345678901234567890", ""},
+		{`{"sku":"A-1","id":"other"}`, "sku", "A-1", ""},
+		{`{"name":"a"}`, "id", "", "missing key field id"},
+		{`{"id":null}`, "id", "", "missing key field id"},
+		{`{"id":""}`, "id", "", "empty key field id"},
+		{`{"id":{"a":1}}`, "id", "", "must be a string or a number"},
+		{`{"id":true}`, "id", "", "must be a string or a number"},
+		{`[1,2]`, "id", "", "not a JSON object"},
+		{`"text"`, "id", "", "not a JSON object"},
+	} {
+		doc, err := newDocument("source", json.RawMessage(c.body), c.keyField)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, expected %q", c.body, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.body, err)
+		} else if doc.key != c.key || string(doc.body) != c.body {
+			t.Errorf("%s: key %q body %s, expected key %q", c.body, doc.key, doc.body, c.key)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name    string
+		content string
+		keys    []string
+		sources []string
+		err     string
+	}{
+		{"lines.jsonl", "{\"id\":\"a\"}\n\n  {\"id\":2}  \n{\"id\":\"c\"}\n", []string{"a", "2", "c"},
+			[]string{"lines.jsonl:1", "lines.jsonl:3", "lines.jsonl:4"}, ""},
+		{"array.json", `[{"id":"a"},{"id":"b"}]`, []string{"a", "b"},
+			[]string{"array.json[0]", "array.json[1]"}, ""},
+		{"single.json", "\n{\"id\":\"only\",\"v\":[1,2]}\n", []string{"only"}, []string{"single.json"}, ""},
+		{"bad.jsonl", "{\"id\":\"a\"}\n{\"name\":\"b\"}\n", nil, nil, "bad.jsonl:2: missing key field id"},
+		{"bad.json", `[{"id":"a"},{"id":false}]`, nil, nil, "bad.json[1]: key field id"},
+	} {
+		file := filepath.Join(dir, c.name)
+		if err = ioutil.WriteFile(file, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var keys, sources []string
+		err = readFile(file, "id", func(doc document) bool {
+			keys = append(keys, doc.key)
+			sources = append(sources, filepath.Base(doc.source))
+			return true
+		})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, expected %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if strings.Join(keys, ",") != strings.Join(c.keys, ",") || strings.Join(sources, ",") != strings.Join(c.sources, ",") {
+			t.Errorf("%s: keys %v from %v, expected %v from %v", c.name, keys, sources, c.keys, c.sources)
+		}
+	}
+}
+
+func TestReadFileStopsWhenEmitDeclines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"stop.jsonl", "stop.json"} {
+		content := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"
+		if name == "stop.json" {
+			content = `[{"id":1},{"id":2},{"id":3}]`
+		}
+		file := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		err = readFile(file, "id", func(doc document) bool {
+			count++
+			return count < 2
+		})
+		if err != nil || count != 2 {
+			t.Errorf("%s: emitted %d documents with %v, expected 2", name, count, err)
+		}
+	}
+}
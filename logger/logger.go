@@ -1,44 +1,142 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/syslog"
+	"net/url"
 	"os"
-	"os/signal"
+	"regexp"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
 )
 
-//Loglevels
+// Loglevels
 const (
-    ERR = 0
-    INFO = 1
-    DEBUG = 2
+	ERR   = 0
+	INFO  = 1
+	DEBUG = 2
 )
 
-type rLogger struct {
-    errFp *os.File
-    infoFp *os.File
-    debug *os.File
+const timeFormat = "2006/01/02 15:04:05.000000"
+
+var levelNames = []string{"ERR", "INFO", "DEBUG"}
+
+// output is shared by a logger and every prefixed copy of it
+type output struct {
+	mu      sync.Mutex
+	files   [DEBUG + 1]*os.File
+	console io.Writer
+	level   int
+	syslog  *syslog.Writer
+}
+
+type Logger struct {
+	prefix string
+	out    *output
 }
 
-//Dummy debugging 
-func New(errFile string, infoFile string, debugFile string ) (l *rLogger) {
-    if fp, err := os.Create(errFile); err != nil {
-        log.Errorf("Unable to create log files %s", err)
-    }
+var std = &Logger{out: &output{console: os.Stdout, level: INFO}}
 
-	return
+const redacted = "******"
+
+// minSecretLength is the length from which a secret is redacted wherever it
+// appears. Shorter ones, like password, are common words and only redacted
+// where a credential is formatted: as a value after = or :, quoted or
+// not.
+const minSecretLength = 12
+
+var secrets struct {
+	sync.RWMutex
+	replacer *strings.Replacer
+	short    *regexp.Regexp
+	values   []string
 }
 
-//Closes all the log files
-func (l *Logger) close() {
-	if l.w != nil {
-		l.w.Close()
-		l.w = nil
+// RegisterSecret makes every logger replace s with ****** from now on,
+// both as is and URL encoded
+func RegisterSecret(s string) {
+	if s == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, value := range secrets.values {
+		if value == s {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, s)
+
+	var pairs, short []string
+	for _, value := range secrets.values {
+		forms := []string{value}
+		if escaped := url.QueryEscape(value); escaped != value {
+			forms = append(forms, escaped)
+		}
+		for _, form := range forms {
+			if len(value) >= minSecretLength {
+				pairs = append(pairs, form, redacted)
+			} else {
+				short = append(short, regexp.QuoteMeta(form))
+			}
+		}
 	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+	if len(short) > 0 {
+		secrets.short = regexp.MustCompile(`([=:] ?"?)(?:` + strings.Join(short, "|") + `)([&@"',;\s]|$)`)
+	}
+}
+
+// Redact replaces the registered secrets in m
+func Redact(m string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	if secrets.replacer == nil {
+		return m
+	}
+	m = secrets.replacer.Replace(m)
+	if secrets.short != nil {
+		m = secrets.short.ReplaceAllString(m, "${1}"+redacted+"${2}")
+	}
+	return m
+}
+
+// New creates a logger writing to separate error, info and debug files.
+// A message goes to the file of its own level and of every more verbose
+// level, so the debug file holds everything. An empty name skips that
+// file. Messages up to INFO are also printed on the console.
+func New(errFile string, infoFile string, debugFile string) (l *Logger, err error) {
+	out := &output{console: os.Stdout, level: INFO}
+	for level, name := range []string{errFile, infoFile, debugFile} {
+		if name == "" {
+			continue
+		}
+		fp, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			out.close()
+			return nil, errors.New(fmt.Sprintf("Unable to create log file %s %v", name, err))
+		}
+		out.files[level] = fp
+	}
+	return &Logger{out: out}, nil
+}
+
+// SetDefault makes l the logger used by the package level functions
+func SetDefault(l *Logger) {
+	std = l
+}
+
+func Default() *Logger {
+	return std
+}
+
+// WithPrefix returns a logger sharing the outputs of l that tags every
+// message with [p]
+func (l *Logger) WithPrefix(p string) *Logger {
+	return &Logger{prefix: "[" + p + "] ", out: l.out}
 }
 
 // SetPrefix changes the prefix for an already created logger
@@ -46,66 +144,91 @@ func (l *Logger) SetPrefix(p string) {
 	l.prefix = "[" + p + "] "
 }
 
-// Close closes the logger and the syslog connection
-func (l *Logger) Close() {
-	l.terminate <- true
-	<-l.terminate
-	close(l.terminate)
-	l.close()
-}
-
-// printf is the internal implementation of Printf used by Printf and
-// CoalesceF to format the log message. Returns a formatted string and
-// a boolean indicating whether logging should happen.
-func (l *Logger) printf(level uint, format string, v ...interface{}) (string, bool) {
-	if (l.w != nil || Addr == "") && (l.all || level <= LogLevel) {
-		return fmt.Sprintf(l.prefix+format, v...), true
-	} else {
-		return "", false
-	}
+// SetConsoleLevel sets the most verbose level printed on the console
+func (l *Logger) SetConsoleLevel(level int) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.level = level
 }
 
-// write is used to send a formatted message with a specific level to
-// the appropriate syslog method. If log is false this function does
-// nothing.
-func (l *Logger) write(level uint, m string, log bool) {
-	if log {
-		if Addr != "" {
-			switch level {
-			case LevelError:
-				_ = l.w.Err(m)
-			case LevelInfo:
-				_ = l.w.Info(m)
-			case LevelInternal, LevelDebug:
-				_ = l.w.Debug(m)
-			}
-		} else {
-			priority := syslog.LOG_EMERG
-			switch level {
-			case LevelError:
-				priority = syslog.LOG_ERR
-			case LevelInfo:
-				priority = syslog.LOG_INFO
-			case LevelInternal, LevelDebug:
-				priority = syslog.LOG_DEBUG
-			}
+// EnableSyslog sends every message to the local syslog daemon as well
+func (l *Logger) EnableSyslog(tag string) (err error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return err
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.syslog = w
+	return nil
+}
 
-			s := C.CString(m)
-			C.csyslog(C.int(priority), s)
-			C.free(unsafe.Pointer(s))
+func (out *output) close() {
+	for level, fp := range out.files {
+		if fp != nil {
+			fp.Close()
+			out.files[level] = nil
 		}
+	}
+	if out.syslog != nil {
+		out.syslog.Close()
+		out.syslog = nil
+	}
+}
 
-		l.Logged = m
-	} else {
-		l.Logged = ""
+// Close closes the log files and the syslog connection
+func (l *Logger) Close() {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.close()
+}
+
+// Printf is like fmt.Printf but goes to the outputs of the given level
+func (l *Logger) Printf(level int, format string, v ...interface{}) {
+	if level < ERR {
+		level = ERR
+	} else if level > DEBUG {
+		level = DEBUG
+	}
+	m := Redact(l.prefix + strings.TrimSpace(fmt.Sprintf(format, v...)))
+	line := fmt.Sprintf("%s %-5s %s\n", time.Now().Format(timeFormat), levelNames[level], m)
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	for fileLevel := level; fileLevel <= DEBUG; fileLevel++ {
+		if fp := l.out.files[fileLevel]; fp != nil {
+			fp.WriteString(line)
+		}
+	}
+	if level <= l.out.level && l.out.console != nil {
+		io.WriteString(l.out.console, line)
+	}
+	if l.out.syslog != nil {
+		switch level {
+		case ERR:
+			l.out.syslog.Err(m)
+		case INFO:
+			l.out.syslog.Info(m)
+		default:
+			l.out.syslog.Debug(m)
+		}
 	}
 }
 
-// Printf is like fmt.Printf but goes to the logger if the log level
-// is correct
-func (l *Logger) Printf(level uint, format string, v ...interface{}) {
-	m, log := l.printf(level, format, v...)
-	l.write(level, m, log)
+// Fatalf logs at ERR and exits
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.Printf(ERR, format, v...)
+	l.Close()
+	os.Exit(1)
 }
 
+// Printf logs through the default logger
+func Printf(level int, format string, v ...interface{}) {
+	std.Printf(level, format, v...)
+}
 
+// Fatalf logs at ERR through the default logger and exits
+func Fatalf(format string, v ...interface{}) {
+	std.Fatalf(format, v...)
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"os"
 	"strings"
-	"time"
 )
 
 func mapExecutors(config *Config) {
 	for _, situation := range config.situation {
-		if strings.EqualFold(situation.Id, "AddRb") {
-			executor := &AddRbExecutor{}
+		if strings.EqualFold(situation.Id, "AddRb") ||
+			strings.EqualFold(situation.Id, "RemoveRb") ||
+			strings.EqualFold(situation.Id, "FoRb") {
+			executor := &RebalanceExecutor{}
 			config.executors = append(config.executors, executor)
 		}
 		if strings.EqualFold(situation.Id, "passthrough") {
@@ -20,28 +22,26 @@ func mapExecutors(config *Config) {
 	}
 }
 
-func main() {
-	start := time.Now()
-	config := LoadConfig("config.json",
-		"resources/data-manipulation-options.json",
-		"resources/situation-options.json")
-
-	//Map executors to the config
-	mapExecutors(&config)
-
-	for description, executor := range config.executors {
-		var duration time.Duration
-		fmt.Printf("\n %v", description)
-		if err := executor.Setup(&config); err == nil {
-			duration = executor.Run()
+func setupLogger(options *LogOptions) (l *logger.Logger, err error) {
+	if options == nil {
+		return logger.Default(), nil
+	}
+	if l, err = logger.New(options.ErrorFile, options.InfoFile, options.DebugFile); err != nil {
+		return nil, err
+	}
+	if options.Verbose {
+		l.SetConsoleLevel(logger.DEBUG)
+	}
+	if options.Syslog != "" {
+		if err = l.EnableSyslog(options.Syslog); err != nil {
+			l.Close()
+			return nil, err
 		}
-
-		executor.TearDown()
-		fmt.Printf("\n Completed in %v", duration.String)
-		fmt.Printf("\n----------------------------------\n")
 	}
+	logger.SetDefault(l)
+	return l, nil
+}
 
-	duration := time.Since(start)
-	fmt.Printf("\n Time taken for Execution of the tests %s\n", duration.String())
-
+func main() {
+	os.Exit(runCli(os.Args[1:]))
 }
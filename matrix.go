@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"strings"
+	"time"
+)
+
+// MatrixResult is the outcome of one combination of the matrix
+type MatrixResult struct {
+	RunId      int
+	Situation  string
+	Action     string
+	BucketType string
+	Duration   time.Duration
+	Err        error
+}
+
+// ExpandMatrix returns one config per situation, action and bucket type.
+// Each of them holds a single situation and action, only the replications
+// of its bucket type and a run id that keeps its buckets and indexes apart
+// from the other runs.
+func ExpandMatrix(config *Config) (runs []Config) {
+	var bucketTypes []string
+	for _, replication := range config.Replications {
+		known := false
+		for _, bucketType := range bucketTypes {
+			if strings.EqualFold(bucketType, replication.BucketType) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			bucketTypes = append(bucketTypes, replication.BucketType)
+		}
+	}
+
+	for _, situation := range config.situation {
+		for _, action := range config.actions {
+			for _, bucketType := range bucketTypes {
+				run := *config
+				run.situation = []Situation{situation}
+				run.actions = []Action{action}
+				run.action = &run.actions[0]
+				run.executors = nil
+				run.runId = len(runs)
+				run.CBNodes = append([]CouchbaseNode(nil), config.CBNodes...)
+				run.ESNodes = append([]ESNode(nil), config.ESNodes...)
+				run.Replications = nil
+				for _, replication := range config.Replications {
+					if strings.EqualFold(bucketType, replication.BucketType) {
+						run.Replications = append(run.Replications, replication)
+					}
+				}
+				runs = append(runs, run)
+			}
+		}
+	}
+	return runs
+}
+
+// RunMatrix runs every combination of the config in sequence and returns
+// their results in the same order
+func RunMatrix(config *Config) (results []MatrixResult) {
+	runs := ExpandMatrix(config)
+	var started []*CouchbaseNode
+	for index := range runs {
+		run := &runs[index]
+		result := MatrixResult{
+			RunId:      run.runId,
+			Situation:  run.situation[0].Id,
+			Action:     run.action.Id,
+			BucketType: run.Replications[0].BucketType,
+		}
+		logger.Printf(logger.INFO, "Run %d/%d: situation %s, data manipulation %s, bucket type %s",
+			index+1, len(runs), result.Situation, result.Action, result.BucketType)
+
+		start := time.Now()
+		result.Err = runOne(run)
+		result.Duration = time.Since(start)
+		for _, executor := range run.executors {
+			if ex, ok := executor.(*RebalanceExecutor); ok {
+				started = appendNodes(started, ex.activeCBNodes)
+			}
+		}
+
+		logger.Printf(logger.INFO, "Completed in %v", result.Duration)
+		logger.Printf(logger.INFO, "----------------------------------")
+		results = append(results, result)
+	}
+
+	//Later combinations run on the same nodes, so couchbase is only
+	//stopped once all of them are done
+	stopServices(started)
+	return results
+}
+
+// appendNodes appends the nodes that are not in list yet
+func appendNodes(list []*CouchbaseNode, nodes []*CouchbaseNode) []*CouchbaseNode {
+	for _, node := range nodes {
+		found := false
+		for _, n := range list {
+			if n.Ip == node.Ip && n.Port == node.Port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, node)
+		}
+	}
+	return list
+}
+
+func stopServices(nodes []*CouchbaseNode) {
+	for _, node := range nodes {
+		if err := node.StopService(); err != nil {
+			logger.Printf(logger.ERR, "Unable to stop couchbase service on node %s %v", node.Ip, err)
+		} else {
+			logger.Printf(logger.INFO, "Stopped the couchbase service on node %s", node.Ip)
+		}
+	}
+}
+
+func runOne(config *Config) (err error) {
+	mapExecutors(config)
+	if len(config.executors) == 0 {
+		return errors.New(fmt.Sprintf("No executor for situation %s", config.situation[0].Id))
+	}
+
+	for _, executor := range config.executors {
+		if err = executor.Setup(config); err == nil {
+			executor.Run()
+			err = executor.Result()
+		}
+		if tearDownErr := executor.TearDown(); err == nil {
+			err = tearDownErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintSummary logs a table of the results and returns false if any of
+// the combinations failed
+func PrintSummary(results []MatrixResult) (passed bool) {
+	passed = true
+	logger.Printf(logger.INFO, "Summary")
+	logger.Printf(logger.INFO, "%-4s %-12s %-12s %-12s %12s %s", "#", "situation", "action", "bucket-type", "duration", "result")
+	for _, result := range results {
+		outcome := "PASS"
+		if result.Err != nil {
+			outcome = fmt.Sprintf("FAIL %v", result.Err)
+			passed = false
+		}
+		logger.Printf(logger.INFO, "%-4d %-12s %-12s %-12s %12s %s", result.RunId, result.Situation,
+			result.Action, result.BucketType, result.Duration.Truncate(time.Millisecond), outcome)
+	}
+	return passed
+}
@@ -3,24 +3,38 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/proxy"
 	"time"
 )
 
 type PassthroughExecutor struct {
 	activeCBNodes      []*CouchbaseNode
 	activeESNodes      []*ESNode
+	esCluster          *ESCluster
 	replicationMapping map[string]string
+	config             *Config
+	bucketname         string
+	indexname          string
 	count              int
-	document           string
+	data               *DataSource
+	proxyServer        *proxy.ProxyServer
+	log                *logger.Logger
+	referenced         bool
+	result             error
 }
 
-func (ex *PassthroughExecutor) Setup(config *Config) (err error) {	
+func (ex *PassthroughExecutor) Setup(config *Config) (err error) {
+	ex.config = config
+	ex.log = logger.Default().WithPrefix("passthrough")
+	ex.bucketname = config.BucketName(0)
+	ex.indexname = config.IndexName(0)
+
 	for index, _ := range config.CBNodes {
 		node := &config.CBNodes[index]
-		fmt.Printf("\n Starting the couchbase on node %s", node.Ip)
+		ex.log.Printf(logger.INFO, "Starting the couchbase on node %s", node.Ip)
 		if err = node.Init(); err != nil {
-			fmt.Printf("Error initializing couchbase node %v", err)
+			ex.log.Printf(logger.ERR, "Error initializing couchbase node %v", err)
 			return err
 		}
 		ex.activeCBNodes = append(ex.activeCBNodes, node)
@@ -28,89 +42,114 @@ func (ex *PassthroughExecutor) Setup(config *Config) (err error) {
 
 	for index, _ := range config.ESNodes {
 		node := &config.ESNodes[index]
-		fmt.Printf("\n Initializing elastic search on node %s", node.Ip)
-		node.Init()
+		ex.log.Printf(logger.INFO, "Initializing elastic search on node %s", node.Ip)
+		if err = node.Init(); err != nil {
+			ex.log.Printf(logger.ERR, "%v", err)
+			return err
+		}
 		ex.activeESNodes = append(ex.activeESNodes, node)
-	} 
+	}
+	if ex.esCluster, err = NewESCluster(ex.activeESNodes); err != nil {
+		return err
+	}
+	if version, err := ex.esCluster.Client().Version(); err == nil {
+		ex.log.Printf(logger.INFO, "Using elastic search %s request formats", version)
+	}
 
 	//create bucket
 	if len(ex.activeCBNodes) > 0 {
-		bucketname := "NewBucket"
+		bucketname := ex.bucketname
 		node := ex.activeCBNodes[0]
 		if err = node.CreateBucket(bucketname); err != nil {
-			fmt.Printf("\n Error creating bucket %v \n", err)
+			ex.log.Printf(logger.ERR, "Error creating bucket %v", err)
 			return err
 		} else {
-			fmt.Printf("\n Created bucket %s\n", bucketname)
+			ex.log.Printf(logger.INFO, "Created bucket %s", bucketname)
+			ex.replicationMapping = map[string]string{bucketname: ex.indexname}
 			time.Sleep(time.Second)
 			if err = node.ConnectToBucket(bucketname); err != nil {
-				log.Fatalf("%v", err)
+				ex.log.Printf(logger.ERR, "Error connecting to bucket %s %v", bucketname, err)
+				return err
 			}
 		}
 	} else {
 		return errors.New("No couchbase nodes initialized")
 	}
-	time.Sleep(1 * time.Second)
 
 	//create index
-	if len(ex.activeESNodes) > 0 {
-		indexname := "newindex"
-		esNode := ex.activeESNodes[0]
-		if err = esNode.CreateIndex(indexname); err != nil {
-			fmt.Printf("\n Error creating index %v \n", err)
-			return err
-		} else {
-			fmt.Printf("\n Created index %s\n", indexname)
-		}
-	} else {
-		return errors.New("No elastic search node initialized")
+	if err = SetupIndex(config, ex.esCluster.Client(), ex.indexname, ex.log); err != nil {
+		ex.log.Printf(logger.ERR, "%v", err)
+		return err
 	}
 
-	//Add to mapping - now done here but should be done prior to this
-	ex.replicationMapping = make(map[string]string)
-	ex.replicationMapping["NewBucket"] = "newindex"
+	//Route the replication through the proxy when it is switched on
+	if ex.proxyServer, err = StartProxy(config.Proxy, ex.activeESNodes[0]); err != nil {
+		ex.log.Printf(logger.ERR, "Error starting the proxy %v", err)
+		return err
+	}
 
 	ex.count = config.Replications[0].ItemCount
+	ex.data = NewDataSource(config, 0, ex.activeCBNodes[0], ex.log)
+	SetupExpiry(config, ex.activeCBNodes, ex.bucketname, ex.log)
 
 	return nil
 }
 
 func (ex *PassthroughExecutor) TearDown() (err error) {
-	//delete buckets
-	/*if len(ex.activeCBNodes) > 0 {
-			bucketname := "NewBucket"
-			couchbaseNode := ex.activeCBNodes[0]
-			if err = couchbaseNode.DeleteBucket(bucketname); err != nil {
-				fmt.Printf("\n Error deleting bucket %v", err)
-	            return err
-			} else {
-				fmt.Printf("\n Deleted bucket %s \n", bucketname)
-			}
-		}*/
 	if len(ex.activeESNodes) > 0 {
-		indexname := "newindex"
-		esNode := ex.activeESNodes[0]
+		StopProxy(ex.proxyServer, ex.activeESNodes[0])
+	}
+
+	//delete the replication, the remote cluster reference and the bucket
+	if _, created := ex.replicationMapping[ex.bucketname]; created {
+		bucketname := ex.bucketname
+		couchbaseNode := ex.activeCBNodes[0]
+		if err = couchbaseNode.CancelReplicationsOf([]string{bucketname}); err != nil {
+			ex.log.Printf(logger.ERR, "Error cancelling the replication of %s %v", bucketname, err)
+			return err
+		}
+		if ex.referenced {
+			if err = couchbaseNode.DeleteRemoteClusterReference(remoteClusterName); err != nil {
+				ex.log.Printf(logger.ERR, "Error deleting the remote cluster reference %v", err)
+				return err
+			}
+			ex.referenced = false
+		}
+		if err = couchbaseNode.DeleteBucket(bucketname); err != nil {
+			ex.log.Printf(logger.ERR, "Error deleting bucket %v", err)
+			return err
+		} else {
+			ex.log.Printf(logger.INFO, "Deleted bucket %s", bucketname)
+		}
+	}
+	if ex.esCluster != nil {
+		indexname := ex.indexname
+		esNode := ex.esCluster.Client()
 		if err = esNode.DeleteIndex(indexname); err != nil {
-			fmt.Printf("\n Error deleting index %v \n", err)
+			ex.log.Printf(logger.ERR, "Error deleting index %v", err)
 			return err
 		} else {
-			fmt.Printf("\n Deleted index %s \n", indexname)
+			ex.log.Printf(logger.INFO, "Deleted index %s", indexname)
+		}
+		if err = TearDownIndex(ex.config, esNode, ex.log); err != nil {
+			ex.log.Printf(logger.ERR, "%v", err)
+			return err
 		}
 	}
 
 	/*for _, node := range ex.activeCBNodes {
-		fmt.Printf("\n Stopping the service on node %s", node.Ip)
+		ex.log.Printf(logger.INFO, "Stopping the service on node %s", node.Ip)
 		if err = node.StopService(); err != nil {
-			fmt.Printf("Error stopping the service: %v", err)
+			ex.log.Printf(logger.ERR, "Error stopping the service: %v", err)
 			return err
 		}
 		ex.activeCBNodes = append(ex.activeCBNodes, node)
 	}*/
 	/*
 			for _, node := range ex.activeESNodes {
-				fmt.Printf("\n Stopping the elastic search service on node %s", node.Ip)
+				ex.log.Printf(logger.INFO, "Stopping the elastic search service on node %s", node.Ip)
 				if err = node.StopService(); err != nil {
-					fmt.Printf("Error stopping the elastic search service: %v", err)
+					ex.log.Printf(logger.ERR, "Error stopping the elastic search service: %v", err)
 		            return err
 				}
 			} */
@@ -120,31 +159,46 @@ func (ex *PassthroughExecutor) TearDown() (err error) {
 func (ex *PassthroughExecutor) Run() time.Duration {
 	couchbaseNode := ex.activeCBNodes[0]
 
-	for i := 0; i < ex.count; i++ {
-		if err := couchbaseNode.DoOp("SET", fmt.Sprintf("%s_%d", "key", i), nil); err != nil {
-			fmt.Printf("error %v", err)
-			break
-		}
+	ex.data.Run(ex.count)
+	if err := ex.data.Err(); err != nil {
+		ex.log.Printf(logger.ERR, "error %v", err)
+		ex.result = err
 	}
+	expected := ex.data.Expected()
 
 	//Create Replication between NewBucket and TestIndex
-	esNode := ex.activeESNodes[0]
-	if err := couchbaseNode.CreateRemoteClusterReference(esNode); err != nil {
-		fmt.Printf("Error creating remote cluster reference %v", err)
+	esNode := ex.esCluster.Client()
+	if err := couchbaseNode.CreateRemoteClusterReference(ex.activeESNodes[0]); err != nil {
+		ex.log.Printf(logger.ERR, "Error creating remote cluster reference %v", err)
+	} else {
+		ex.referenced = true
 	}
 
 	//Start Replication
 	for bucket, index := range ex.replicationMapping {
 		if err := couchbaseNode.CreateReplication(bucket, index); err != nil {
-			fmt.Printf("Error starting the replication %v", err)
+			ex.log.Printf(logger.ERR, "Error starting the replication %v", err)
 		}
 	}
-	time.Sleep(1 * time.Minute)
-
 	//Verify Results
-	/*if esNode.GetCount("newindex") == ex.count {
-		fmt.Printf("\n Success !!\n")
-	}*/
+	replicatedCount, err := esNode.WaitForCount(ex.indexname, expected, ex.data.Uncounted(), time.Minute)
+	if err != nil {
+		ex.result = err
+	} else if replicatedCount != expected {
+		ex.result = errors.New(fmt.Sprintf("replicated %d of %d items", replicatedCount, expected))
+	} else if err = ex.data.VerifyKeys(esNode, ex.indexname); err != nil {
+		ex.result = err
+	} else if err = VerifyExpiry(ex.config, ex.data, couchbaseNode, esNode, ex.bucketname, ex.indexname, ex.log); err != nil {
+		ex.result = err
+	} else if err = VerifyValues(ex.config, couchbaseNode, esNode, ex.indexname, ex.log); err != nil {
+		ex.result = err
+	} else if ex.result == nil {
+		ex.log.Printf(logger.INFO, "Success !!")
+	}
 
 	return 0
 }
+
+func (ex *PassthroughExecutor) Result() error {
+	return ex.result
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/bsubhashni/go-cbes/logger"
+	"net"
+	"net/http"
+	"time"
+)
+
+type Status struct {
+	Upstream      string                   `json:"upstream"`
+	Port          int                      `json:"port"`
+	Started       time.Time                `json:"started"`
+	Uptime        string                   `json:"uptime"`
+	HttpTimeout   int                      `json:"http-timeout"`
+	RetryInterval int                      `json:"retry-interval"`
+	Retries       int                      `json:"retries"`
+	InFlight      int64                    `json:"in-flight"`
+	Unauthorized  int64                    `json:"unauthorized"`
+	TLS           bool                     `json:"tls"`
+	UpstreamTLS   bool                     `json:"upstream-tls"`
+	Faults        []Fault                  `json:"faults"`
+	Endpoints     map[string]EndpointStats `json:"endpoints"`
+}
+
+// StartAdmin serves /metrics in prometheus text format and /status as JSON
+// on the admin listener
+func (server *ProxyServer) StartAdmin(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", server.MetricsHandler)
+	mux.HandleFunc("/status", server.StatusHandler)
+
+	adminServer := &http.Server{Handler: mux}
+	server.mu.Lock()
+	server.adminServer = adminServer
+	server.mu.Unlock()
+
+	server.log().Printf(logger.INFO, "Starting admin listener on port %d", server.AdminPort)
+	if err := adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		server.log().Printf(logger.ERR, "Admin listener stopped %v", err)
+	}
+}
+
+func (server *ProxyServer) MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(server.Metrics.Prometheus())
+}
+
+func (server *ProxyServer) StatusHandler(w http.ResponseWriter, req *http.Request) {
+	settings := server.settings()
+	status := Status{
+		Upstream:      settings.Upstream,
+		Port:          server.Port,
+		Started:       server.Metrics.started,
+		Uptime:        time.Since(server.Metrics.started).String(),
+		HttpTimeout:   settings.HttpTimeout,
+		RetryInterval: settings.RetryInterval,
+		Retries:       settings.Retries,
+		InFlight:      server.Metrics.InFlight(),
+		Unauthorized:  server.Metrics.Rejected(),
+		TLS:           server.TLSCertFile != "",
+		UpstreamTLS:   settings.UpstreamTLS,
+		Faults:        settings.Faults,
+		Endpoints:     server.Metrics.Snapshot(),
+	}
+
+	body, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
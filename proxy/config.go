@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/secret"
+	"io/ioutil"
+	"net/http"
+)
+
+// ProxyConfig holds the settings that can be changed while the proxy is
+// running. It is read from the -config file at start up and on SIGHUP.
+type ProxyConfig struct {
+	Upstream         string            `json:"upstream"`
+	UpstreamUser     string            `json:"upstream-username"`
+	UpstreamPassword string            `json:"upstream-password"`
+	UpstreamTLS      bool              `json:"upstream-tls"`
+	UpstreamCAFile   string            `json:"upstream-ca-file"`
+	UpstreamInsecure bool              `json:"upstream-insecure-skip-verify"`
+	Users            map[string]string `json:"users"`
+	HttpTimeout      int               `json:"http-timeout"`
+	RetryInterval    int               `json:"retry-interval"`
+	Retries          int               `json:"retries"`
+	Faults           []Fault           `json:"faults"`
+}
+
+// LoadProxyConfig reads the config file on top of current, so fields that
+// are missing from the file keep their current value. faults and users are
+// replaced as a whole when present, "faults": [] clears the faults.
+func LoadProxyConfig(fileName string, current ProxyConfig) (config ProxyConfig, err error) {
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return current, err
+	}
+
+	//decode into fresh ones so the file does not merge into the current
+	config = current
+	config.Faults = nil
+	config.Users = nil
+	if err = json.Unmarshal(bytes, &config); err != nil {
+		return current, err
+	}
+	if config.Faults == nil {
+		config.Faults = current.Faults
+	}
+	if config.Users == nil {
+		config.Users = current.Users
+	}
+	if err = config.resolveCredentials(); err != nil {
+		return current, err
+	}
+	return config, nil
+}
+
+// resolveCredentials replaces env: and file: references in the upstream
+// credentials and the user passwords by their values
+func (config *ProxyConfig) resolveCredentials() (err error) {
+	if config.UpstreamUser, err = secret.Resolve(config.UpstreamUser); err != nil {
+		return errors.New(fmt.Sprintf("upstream-username: %v", err))
+	}
+	if config.UpstreamPassword, err = secret.ResolvePassword(config.UpstreamPassword); err != nil {
+		return errors.New(fmt.Sprintf("upstream-password: %v", err))
+	}
+	users := make(map[string]string)
+	for user, password := range config.Users {
+		if users[user], err = secret.ResolvePassword(password); err != nil {
+			return errors.New(fmt.Sprintf("users.%s: %v", user, err))
+		}
+	}
+	if config.Users != nil {
+		config.Users = users
+	}
+	return nil
+}
+
+func (server *ProxyServer) settings() ProxyConfig {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return ProxyConfig{
+		Upstream:         server.Upstream,
+		UpstreamUser:     server.UpstreamUser,
+		UpstreamPassword: server.UpstreamPassword,
+		UpstreamTLS:      server.UpstreamTLS,
+		UpstreamCAFile:   server.UpstreamCAFile,
+		UpstreamInsecure: server.UpstreamInsecure,
+		Users:            server.Users,
+		HttpTimeout:      server.HttpTimeout,
+		RetryInterval:    server.RetryInterval,
+		Retries:          server.Retries,
+		Faults:           server.Faults,
+	}
+}
+
+// apply swaps in the new settings and rebuilds the upstream client
+func (server *ProxyServer) apply(config ProxyConfig) (err error) {
+	client, err := newUpstreamClient(config)
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.Upstream = config.Upstream
+	server.UpstreamUser = config.UpstreamUser
+	server.UpstreamPassword = config.UpstreamPassword
+	server.UpstreamTLS = config.UpstreamTLS
+	server.UpstreamCAFile = config.UpstreamCAFile
+	server.UpstreamInsecure = config.UpstreamInsecure
+	server.Users = config.Users
+	server.RetryInterval = config.RetryInterval
+	server.Retries = config.Retries
+	server.Faults = config.Faults
+	server.HttpTimeout = config.HttpTimeout
+	server.Client = client
+	if server.Metrics != nil {
+		server.Metrics.SetUpstream(config.Upstream)
+	}
+	return nil
+}
+
+func (server *ProxyServer) client() *http.Client {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.Client
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Fault delays and/or fails calls to one upstream endpoint. Delay is in
+// milliseconds and ErrorRate is the fraction of calls, between 0 and 1,
+// answered with StatusCode instead of being forwarded.
+type Fault struct {
+	Endpoint   string  `json:"endpoint"`
+	Delay      int     `json:"delay"`
+	ErrorRate  float64 `json:"error-rate"`
+	StatusCode int     `json:"status-code"`
+}
+
+func findFault(faults []Fault, endpoint string) *Fault {
+	for index := range faults {
+		if faults[index].Endpoint == endpoint || faults[index].Endpoint == "*" {
+			return &faults[index]
+		}
+	}
+	return nil
+}
+
+// inject applies the delay and decides whether the call fails. When it
+// does, the returned response replaces the upstream one.
+func (fault *Fault) inject() (resp *http.Response, body []byte, injected bool) {
+	if fault.Delay > 0 {
+		time.Sleep(time.Duration(fault.Delay) * time.Millisecond)
+	}
+	if fault.ErrorRate <= 0 || rand.Float64() >= fault.ErrorRate {
+		return nil, nil, false
+	}
+
+	status := fault.StatusCode
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	body = []byte(fmt.Sprintf(`{"error":"injected fault","reason":"%s"}`, fault.Endpoint))
+	resp = &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	return resp, body, true
+}
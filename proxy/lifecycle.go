@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultDrainTimeout = 30
+)
+
+func (server *ProxyServer) writePidFile() (err error) {
+	if server.PidFile == "" {
+		return nil
+	}
+	pid := os.Getpid()
+	server.log().Printf(logger.INFO, "pid of the process %d", pid)
+	return ioutil.WriteFile(server.PidFile, []byte(fmt.Sprintf("%d\n", pid)), 0644)
+}
+
+func (server *ProxyServer) removePidFile() {
+	if server.PidFile == "" {
+		return
+	}
+	if err := os.Remove(server.PidFile); err != nil && !os.IsNotExist(err) {
+		server.log().Printf(logger.ERR, "Unable to remove pid file %s %v", server.PidFile, err)
+	}
+}
+
+// Shutdown stops accepting connections and waits up to DrainTimeout
+// seconds for in-flight requests such as _bulk_docs to finish. Before
+// Listen it is recorded and carried out once the ports are bound.
+func (server *ProxyServer) Shutdown() (err error) {
+	server.mu.Lock()
+	httpServer, adminServer := server.httpServer, server.adminServer
+	listener, adminListener := server.listener, server.adminListener
+	server.httpServer, server.adminServer = nil, nil
+	if httpServer == nil {
+		server.shutdownPending = true
+	}
+	server.mu.Unlock()
+	if httpServer == nil {
+		return nil
+	}
+
+	drainTimeout := server.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(drainTimeout)*time.Second)
+	defer cancel()
+
+	server.log().Printf(logger.INFO, "Draining %d in-flight requests", server.Metrics.InFlight())
+	err = httpServer.Shutdown(ctx)
+	if err != nil {
+		server.log().Printf(logger.ERR, "Drain did not finish in %d seconds %v", drainTimeout, err)
+		httpServer.Close()
+	}
+	//the listeners are only owned by the servers once Serve has run
+	listener.Close()
+	if adminServer != nil {
+		adminServer.Close()
+	} else if adminListener != nil {
+		adminListener.Close()
+	}
+	server.removePidFile()
+	close(server.done)
+	return err
+}
+
+// Reload re-reads the config file and applies the upstream, retry and
+// fault settings to the requests that follow
+func (server *ProxyServer) Reload() (err error) {
+	if server.ConfigFile == "" {
+		server.log().Printf(logger.INFO, "No config file to reload")
+		return nil
+	}
+	config, err := LoadProxyConfig(server.ConfigFile, server.settings())
+	if err != nil {
+		server.log().Printf(logger.ERR, "Unable to reload config %s %v", server.ConfigFile, err)
+		return err
+	}
+	if err = server.apply(config); err != nil {
+		server.log().Printf(logger.ERR, "Unable to apply reloaded config %s %v", server.ConfigFile, err)
+		return err
+	}
+	server.log().Printf(logger.INFO, "Reloaded config upstream %s faults %d", config.Upstream, len(config.Faults))
+	return nil
+}
+
+// HandleSignals reloads the config on SIGHUP and shuts the server down on
+// SIGTERM or SIGINT
+func (server *ProxyServer) HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	for sig := range signals {
+		server.log().Printf(logger.INFO, "Got signal %v", sig)
+		if sig == syscall.SIGHUP {
+			server.Reload()
+			continue
+		}
+		server.Shutdown()
+		return
+	}
+}
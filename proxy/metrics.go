@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the latency histogram buckets in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Endpoints the capi server exposes, anything else is reported as other
+var knownEndpoints = []string{
+	"_bulk_docs",
+	"_revs_diff",
+	"_pre_replicate",
+	"_commit_for_checkpoint",
+	"_ensure_full_commit",
+	"pools",
+}
+
+type EndpointStats struct {
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	Retries       int64     `json:"retries"`
+	Faults        int64     `json:"injected-faults"`
+	BytesSent     int64     `json:"bytes-sent"`
+	BytesReceived int64     `json:"bytes-received"`
+	LatencySum    float64   `json:"latency-sum-seconds"`
+	LatencyMax    float64   `json:"latency-max-seconds"`
+	LastRequest   time.Time `json:"last-request"`
+	buckets       []int64
+}
+
+type Metrics struct {
+	mu        sync.Mutex
+	upstream  string
+	started   time.Time
+	inFlight  int64
+	rejected  int64
+	endpoints map[string]*EndpointStats
+}
+
+func NewMetrics(upstream string) *Metrics {
+	return &Metrics{
+		upstream:  upstream,
+		started:   time.Now(),
+		endpoints: make(map[string]*EndpointStats),
+	}
+}
+
+// endpointOf maps an upstream url to one of the known capi endpoints
+func endpointOf(u *url.URL) string {
+	path := u.Opaque
+	if path == "" {
+		path = u.Path
+	}
+	for _, endpoint := range knownEndpoints {
+		if strings.Contains(path, endpoint) {
+			return endpoint
+		}
+	}
+	return "other"
+}
+
+func (m *Metrics) stats(endpoint string) *EndpointStats {
+	stats, ok := m.endpoints[endpoint]
+	if !ok {
+		stats = &EndpointStats{buckets: make([]int64, len(latencyBuckets))}
+		m.endpoints[endpoint] = stats
+	}
+	return stats
+}
+
+// Observe records a single upstream call
+func (m *Metrics) Observe(endpoint string, latency time.Duration, sent int, received int, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats(endpoint)
+	seconds := latency.Seconds()
+	stats.Requests++
+	if failed {
+		stats.Errors++
+	}
+	stats.BytesSent += int64(sent)
+	stats.BytesReceived += int64(received)
+	stats.LatencySum += seconds
+	if seconds > stats.LatencyMax {
+		stats.LatencyMax = seconds
+	}
+	stats.LastRequest = time.Now()
+	for index, bound := range latencyBuckets {
+		if seconds <= bound {
+			stats.buckets[index]++
+		}
+	}
+}
+
+func (m *Metrics) Retry(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(endpoint).Retries++
+}
+
+func (m *Metrics) Fault(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(endpoint).Faults++
+}
+
+// Begin and End bracket a request that is being forwarded
+func (m *Metrics) Begin() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+func (m *Metrics) End() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// Unauthorized counts requests rejected for bad XDCR credentials
+func (m *Metrics) Unauthorized() {
+	atomic.AddInt64(&m.rejected, 1)
+}
+
+func (m *Metrics) Rejected() int64 {
+	return atomic.LoadInt64(&m.rejected)
+}
+
+func (m *Metrics) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
+func (m *Metrics) SetUpstream(upstream string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upstream = upstream
+}
+
+// Snapshot returns a copy of the per endpoint stats
+func (m *Metrics) Snapshot() map[string]EndpointStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]EndpointStats)
+	for endpoint, stats := range m.endpoints {
+		copied := *stats
+		copied.buckets = append([]int64(nil), stats.buckets...)
+		snapshot[endpoint] = copied
+	}
+	return snapshot
+}
+
+// Prometheus renders the metrics in the prometheus text exposition format
+func (m *Metrics) Prometheus() []byte {
+	snapshot := m.Snapshot()
+	m.mu.Lock()
+	upstream := m.upstream
+	m.mu.Unlock()
+
+	var endpoints []string
+	for endpoint := range snapshot {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var buf bytes.Buffer
+	counter := func(name string, help string, value func(EndpointStats) string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, endpoint := range endpoints {
+			fmt.Fprintf(&buf, "%s{upstream=%q,endpoint=%q} %s\n", name, upstream, endpoint, value(snapshot[endpoint]))
+		}
+	}
+
+	counter("esproxy_upstream_requests_total", "Requests sent upstream.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.Requests) })
+	counter("esproxy_upstream_errors_total", "Upstream requests that failed.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.Errors) })
+	counter("esproxy_upstream_retries_total", "Upstream requests that were retried.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.Retries) })
+	counter("esproxy_upstream_injected_faults_total", "Upstream requests answered by an injected fault.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.Faults) })
+	counter("esproxy_upstream_sent_bytes_total", "Request body bytes sent upstream.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.BytesSent) })
+	counter("esproxy_upstream_received_bytes_total", "Response body bytes received from upstream.",
+		func(s EndpointStats) string { return fmt.Sprintf("%d", s.BytesReceived) })
+
+	name := "esproxy_upstream_latency_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Upstream request latency.\n# TYPE %s histogram\n", name, name)
+	for _, endpoint := range endpoints {
+		stats := snapshot[endpoint]
+		for index, bound := range latencyBuckets {
+			fmt.Fprintf(&buf, "%s_bucket{upstream=%q,endpoint=%q,le=\"%g\"} %d\n",
+				name, upstream, endpoint, bound, stats.buckets[index])
+		}
+		fmt.Fprintf(&buf, "%s_bucket{upstream=%q,endpoint=%q,le=\"+Inf\"} %d\n", name, upstream, endpoint, stats.Requests)
+		fmt.Fprintf(&buf, "%s_sum{upstream=%q,endpoint=%q} %g\n", name, upstream, endpoint, stats.LatencySum)
+		fmt.Fprintf(&buf, "%s_count{upstream=%q,endpoint=%q} %d\n", name, upstream, endpoint, stats.Requests)
+	}
+
+	fmt.Fprintf(&buf, "# HELP esproxy_in_flight_requests Requests currently being forwarded.\n# TYPE esproxy_in_flight_requests gauge\n")
+	fmt.Fprintf(&buf, "esproxy_in_flight_requests %d\n", m.InFlight())
+	fmt.Fprintf(&buf, "# HELP esproxy_unauthorized_requests_total Requests rejected for bad credentials.\n# TYPE esproxy_unauthorized_requests_total counter\n")
+	fmt.Fprintf(&buf, "esproxy_unauthorized_requests_total %d\n", m.Rejected())
+	fmt.Fprintf(&buf, "# HELP esproxy_uptime_seconds Seconds since the proxy started.\n# TYPE esproxy_uptime_seconds gauge\n")
+	fmt.Fprintf(&buf, "esproxy_uptime_seconds %g\n", time.Since(m.started).Seconds())
+	return buf.Bytes()
+}
@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"bytes"
+	//	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	//	"os"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	esNodeIP       = "127.0.0.1"
+	esNodeCAPIPort = "9091"
+	testBucket     = "test"
+
+	DefaultUpstreamUser     = "root"
+	DefaultUpstreamPassword = "password"
+)
+
+type ProxyServer struct {
+	Port               int
+	AdminPort          int
+	Upstream           string
+	UpstreamUser       string
+	UpstreamPassword   string
+	UpstreamTLS        bool
+	UpstreamCAFile     string
+	UpstreamInsecure   bool
+	Users              map[string]string
+	TLSCertFile        string
+	TLSKeyFile         string
+	HttpTimeout        int
+	CheckpointInterval int
+	RetryInterval      int
+	Retries            int
+	Faults             []Fault
+	PidFile            string
+	ConfigFile         string
+	DrainTimeout       int
+	Client             *http.Client
+	Metrics            *Metrics
+	Logger             *logger.Logger
+	mu                 sync.RWMutex
+	listener           net.Listener
+	adminListener      net.Listener
+	httpServer         *http.Server
+	adminServer        *http.Server
+	shutdownPending    bool
+	done               chan bool
+}
+
+// Checkpoint is what XDCR commits for a vbucket, the connector keeps it
+// under doc of a couchbaseCheckpoint document
+type Checkpoint struct {
+	Seqno        uint64 `json:"seqno"`
+	FailoverID   uint64 `json:"failoverID"`
+	CommitOpaque uint64 `json:"commitopaque"`
+	VBOpaque     uint64 `json:"vbopaque"`
+	BucketUUID   uint64 `json:"bucketUUId"`
+}
+
+func (server *ProxyServer) init() (err error) {
+	if server.Upstream == "" {
+		server.Upstream = fmt.Sprintf("%s:%s", esNodeIP, esNodeCAPIPort)
+	}
+	if server.UpstreamUser == "" {
+		server.UpstreamUser = DefaultUpstreamUser
+		server.UpstreamPassword = DefaultUpstreamPassword
+	}
+	server.Metrics = NewMetrics(server.Upstream)
+
+	config := server.settings()
+	if err = config.resolveCredentials(); err != nil {
+		return err
+	}
+	if server.ConfigFile != "" {
+		if config, err = LoadProxyConfig(server.ConfigFile, config); err != nil {
+			return err
+		}
+	}
+	if err = server.apply(config); err != nil {
+		return err
+	}
+	server.done = make(chan bool)
+	return nil
+}
+
+func (server *ProxyServer) log() *logger.Logger {
+	if server.Logger == nil {
+		return logger.Default().WithPrefix("proxy")
+	}
+	return server.Logger
+}
+
+func (server *ProxyServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.PoolsHandler)
+	mux.HandleFunc("/pools", server.PoolsHandler)
+	mux.HandleFunc("/_pre_replicate", server.PreReplicateHttpHandler)
+	mux.HandleFunc("/_commit_for_checkpoint", CommitForCheckPointHttpHandler)
+	mux.HandleFunc("/_ensure_full_commit", EnsureFullCommitHandler)
+	return mux
+}
+
+// Start binds the proxy and admin ports, writes the pid file and serves
+// until Shutdown is called. A bind failure is returned straight away.
+func (server *ProxyServer) Start() (err error) {
+	if err = server.Listen(); err != nil {
+		return err
+	}
+	return server.Serve()
+}
+
+// Listen binds the ports and writes the pid file without serving, so a
+// caller embedding the proxy knows it is reachable once this returns
+func (server *ProxyServer) Listen() (err error) {
+	if err = server.init(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", server.Port))
+	if err != nil {
+		return err
+	}
+
+	var adminListener net.Listener
+	if server.AdminPort > 0 {
+		if adminListener, err = net.Listen("tcp", fmt.Sprintf(":%d", server.AdminPort)); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	if err = server.writePidFile(); err != nil {
+		listener.Close()
+		if adminListener != nil {
+			adminListener.Close()
+		}
+		return err
+	}
+
+	server.mu.Lock()
+	server.listener = listener
+	server.adminListener = adminListener
+	server.httpServer = &http.Server{Handler: server.authenticate(server.mux())}
+	pending := server.shutdownPending
+	server.mu.Unlock()
+
+	if pending {
+		server.log().Printf(logger.INFO, "Shutting down as requested before listening")
+		return server.Shutdown()
+	}
+	return nil
+}
+
+// Serve handles requests on the ports bound by Listen until Shutdown
+func (server *ProxyServer) Serve() (err error) {
+	server.mu.RLock()
+	httpServer, listener, adminListener := server.httpServer, server.listener, server.adminListener
+	server.mu.RUnlock()
+	if httpServer == nil {
+		select {
+		case <-server.done:
+			//shut down before serving
+			return nil
+		default:
+			return errors.New("Proxy server is not listening")
+		}
+	}
+
+	if adminListener != nil {
+		go server.StartAdmin(adminListener)
+	}
+
+	server.log().Printf(logger.INFO, "Starting server")
+	if server.TLSCertFile != "" {
+		err = httpServer.ServeTLS(listener, server.TLSCertFile, server.TLSKeyFile)
+	} else {
+		err = httpServer.Serve(listener)
+	}
+	if err == http.ErrServerClosed {
+		//wait for the in-flight requests to drain
+		<-server.done
+		return nil
+	}
+	return err
+}
+
+// forward sends the request to the upstream capi server, retrying on
+// transport errors every RetryInterval milliseconds up to Retries times.
+// Requests that are not reads are only retried when they never got sent.
+// Every attempt is recorded against the endpoint in the metrics.
+func (server *ProxyServer) forward(method string, u *url.URL, body []byte) (resp *http.Response, respBody []byte, err error) {
+	endpoint := endpointOf(u)
+	settings := server.settings()
+
+	server.Metrics.Begin()
+	defer server.Metrics.End()
+
+	if fault := findFault(settings.Faults, endpoint); fault != nil {
+		if resp, respBody, injected := fault.inject(); injected {
+			server.Metrics.Fault(endpoint)
+			return resp, respBody, nil
+		}
+	}
+
+	for attempt := 0; attempt <= settings.Retries; attempt++ {
+		if attempt > 0 {
+			server.Metrics.Retry(endpoint)
+			time.Sleep(time.Duration(settings.RetryInterval) * time.Millisecond)
+		}
+
+		var newReq *http.Request
+		newReq, err = http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		newReq.URL = u
+		newReq.SetBasicAuth(settings.UpstreamUser, settings.UpstreamPassword)
+
+		start := time.Now()
+		resp, err = server.client().Do(newReq)
+		if err != nil {
+			server.Metrics.Observe(endpoint, time.Since(start), len(body), 0, true)
+			server.log().Printf(logger.ERR, "Upstream %s attempt %d failed %v", endpoint, attempt+1, err)
+			if !retryable(method, err) {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		respBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		server.Metrics.Observe(endpoint, time.Since(start), len(body), len(respBody), failed)
+		if err != nil {
+			if !retryable(method, err) {
+				return nil, nil, err
+			}
+			continue
+		}
+		return resp, respBody, nil
+	}
+
+	if err == nil {
+		err = errors.New(fmt.Sprintf("Upstream %s failed after %d attempts", endpoint, settings.Retries+1))
+	}
+	return nil, nil, err
+}
+
+// retryable tells whether a request can be sent upstream again after err.
+// Reads can always be retried, others such as the POST of _bulk_docs only
+// when the connection was never made.
+func retryable(method string, err error) bool {
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func RootHandler(w http.ResponseWriter, req *http.Request) {
+	logger.Printf(logger.DEBUG, "Got request for %s %s", req.Method, req.URL.Path)
+	logger.Printf(logger.DEBUG, "req url %s", req.URL)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *ProxyServer) PoolsHandler(w http.ResponseWriter, req *http.Request) {
+	server.log().Printf(logger.DEBUG, "Root Got request for %s %s", req.Method, req.URL.Path)
+	server.log().Printf(logger.DEBUG, "Referrer url %s", req.Referer())
+
+	settings := server.settings()
+	upstream := settings.Upstream
+	path := fmt.Sprintf("%s://%s%s", settings.scheme(), upstream, req.URL)
+	urlSt, err := url.Parse(path)
+	if err != nil {
+		server.log().Printf(logger.ERR, "Error creating new request %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if strings.Contains(path, ";") || strings.Contains(path, "_bulk_doc") {
+
+		path = req.URL.String()
+		path = url.QueryEscape(path[1 : len(path)-1])
+
+		if strings.Contains(path, "_bulk_doc") {
+			path = strings.Replace(path, "%2F_bulk_doc", "/_bulk_docs", -1)
+		}
+
+		urlSt = &url.URL{
+			Scheme: settings.scheme(),
+			Opaque: fmt.Sprintf("//%s/%s", upstream, path),
+			Host:   upstream,
+		}
+		server.log().Printf(logger.DEBUG, "%s", urlSt.String())
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		server.log().Printf(logger.ERR, "Error reading request body %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, respBody, err := server.forward(req.Method, urlSt, body)
+	if err != nil {
+		server.log().Printf(logger.ERR, "%v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	server.log().Printf(logger.DEBUG, "%s", respBody)
+	newBody := fmt.Sprintf("%s", respBody)
+	newBody = strings.Replace(newBody, upstreamPort(upstream), strconv.Itoa(server.Port), -1)
+	server.log().Printf(logger.DEBUG, "%s", newBody)
+	w.WriteHeader(resp.StatusCode)
+	w.Write([]byte(newBody))
+	return
+}
+
+func upstreamPort(upstream string) string {
+	if index := strings.LastIndex(upstream, ":"); index >= 0 {
+		return upstream[index+1:]
+	}
+	return esNodeCAPIPort
+}
+
+func (server *ProxyServer) PreReplicateHttpHandler(w http.ResponseWriter, req *http.Request) {
+	server.log().Printf(logger.DEBUG, "pre replicate Got request for %s %s", req.Method, req.URL.Path)
+	settings := server.settings()
+	urlSt, err := url.Parse(fmt.Sprintf("%s://%s%s", settings.scheme(), settings.Upstream, req.URL))
+	if err != nil {
+		server.log().Printf(logger.ERR, "Error creating new request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		server.log().Printf(logger.ERR, "Error reading request body %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	//dumb forward to capi server
+	resp, respBody, err := server.forward(req.Method, urlSt, body)
+	if err != nil {
+		server.log().Printf(logger.ERR, "%v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	server.log().Printf(logger.DEBUG, "%s", respBody)
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+	return
+}
+
+func CommitForCheckPointHttpHandler(w http.ResponseWriter, req *http.Request) {
+	logger.Printf(logger.INFO, "Handling checkpointing call")
+
+	w.WriteHeader(http.StatusNotFound)
+	return
+}
+
+func BulkDocsHandler(w http.ResponseWriter, req *http.Request) {
+	logger.Printf(logger.INFO, "Got bulk docs call")
+
+	/*
+	   client = &http.Client {
+	       httpTimeout : httpTimeout
+	   }
+
+	   resp, err := client.Get(fmt.Sprintf("%s"))
+
+	   if err != nil {
+	       logger.Printf(logger.ERR, "Got error %v", err)
+	   } else {
+	       if resp.Status
+	   }
+	*/
+	w.WriteHeader(http.StatusOK)
+	return
+}
+
+func EnsureFullCommitHandler(w http.ResponseWriter, req *http.Request) {
+	logger.Printf(logger.INFO, "Got full commit call")
+	w.WriteHeader(http.StatusNotFound)
+	return
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// newUpstreamClient builds the client used to call the capi server.
+// HttpTimeout is in seconds, zero means no timeout.
+func newUpstreamClient(config ProxyConfig) (client *http.Client, err error) {
+	client = &http.Client{
+		Timeout: time.Duration(config.HttpTimeout) * time.Second,
+	}
+	if !config.UpstreamTLS {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.UpstreamInsecure,
+	}
+	if config.UpstreamCAFile != "" {
+		pem, err := ioutil.ReadFile(config.UpstreamCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in %s", config.UpstreamCAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return client, nil
+}
+
+func (config ProxyConfig) scheme() string {
+	if config.UpstreamTLS {
+		return "https"
+	}
+	return "http"
+}
+
+// authenticate checks the XDCR credentials on the incoming request
+// against the configured users. No users means no authentication.
+func (server *ProxyServer) authenticate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		users := server.settings().Users
+		if len(users) > 0 {
+			username, password, ok := req.BasicAuth()
+			expected, known := users[username]
+			if !ok || !known || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+				server.log().Printf(logger.INFO, "Rejected request for %s from %s", req.URL, req.RemoteAddr)
+				server.Metrics.Unauthorized()
+				w.Header().Set("WWW-Authenticate", `Basic realm="esproxy"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -1,21 +1,72 @@
 package main
 
 import (
-    "fmt"
-    //"logger"
-    "flag"
+	"flag"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/proxy"
 )
 
-
 func main() {
-    port := flag.Int("proxy-port", 3612, "Port to start the proxy server on")
-    flag.Parse()
+	port := flag.Int("proxy-port", 3912, "Port to start the proxy server on")
+	adminPort := flag.Int("admin-port", 0, "Port to serve /metrics and /status on, 0 disables it")
+	upstream := flag.String("upstream", "127.0.0.1:9091", "host:port of the elastic search capi server")
+	httpTimeout := flag.Int("http-timeout", 30, "Timeout in seconds for upstream calls")
+	retryInterval := flag.Int("retry-interval", 500, "Milliseconds to wait before retrying a failed upstream call")
+	retries := flag.Int("retries", 3, "Number of times a failed upstream call is retried")
+	pid := flag.String("pid-file", "", "File to write the pid to, none when empty")
+	configFile := flag.String("config", "", "JSON file with upstream and fault settings, re-read on SIGHUP")
+	drainTimeout := flag.Int("drain-timeout", proxy.DefaultDrainTimeout, "Seconds to wait for in-flight requests on shutdown")
+	tlsCert := flag.String("tls-cert", "", "Certificate file to serve TLS with, empty serves plain HTTP")
+	tlsKey := flag.String("tls-key", "", "Key file for -tls-cert")
+	upstreamUser := flag.String("upstream-username", proxy.DefaultUpstreamUser, "Username to authenticate to the capi server with, env:NAME and file:/path are resolved")
+	upstreamPassword := flag.String("upstream-password", proxy.DefaultUpstreamPassword, "Password to authenticate to the capi server with, env:NAME and file:/path are resolved")
+	upstreamTLS := flag.Bool("upstream-tls", false, "Call the capi server over https")
+	upstreamCA := flag.String("upstream-ca-file", "", "CA certificate to verify the capi server with")
+	upstreamInsecure := flag.Bool("upstream-insecure-skip-verify", false, "Skip verifying the capi server certificate")
+	errorLog := flag.String("error-log", "", "File to write error messages to")
+	infoLog := flag.String("info-log", "", "File to write info messages to")
+	debugLog := flag.String("debug-log", "", "File to write debug messages to")
+	syslogTag := flag.String("syslog", "", "Tag to also log to syslog with, empty disables it")
+	flag.Parse()
+
+	l, err := logger.New(*errorLog, *infoLog, *debugLog)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if *syslogTag != "" {
+		if err = l.EnableSyslog(*syslogTag); err != nil {
+			logger.Fatalf("Unable to connect to syslog %v", err)
+		}
+	}
+	logger.SetDefault(l)
+	defer l.Close()
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		logger.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+
+	server := &proxy.ProxyServer{
+		Port:             *port,
+		AdminPort:        *adminPort,
+		Upstream:         *upstream,
+		UpstreamUser:     *upstreamUser,
+		UpstreamPassword: *upstreamPassword,
+		UpstreamTLS:      *upstreamTLS,
+		UpstreamCAFile:   *upstreamCA,
+		UpstreamInsecure: *upstreamInsecure,
+		TLSCertFile:      *tlsCert,
+		TLSKeyFile:       *tlsKey,
+		HttpTimeout:      *httpTimeout,
+		RetryInterval:    *retryInterval,
+		Retries:          *retries,
+		PidFile:          *pid,
+		ConfigFile:       *configFile,
+		DrainTimeout:     *drainTimeout,
+	}
 
-    server := &ProxyServer {
-        Port: *port,
-    }
-    
-    //logger.Printf(logger.INFO, "Starting up the server")
-    fmt.Printf("Starting up the server")
-    server.Start() 
+	logger.Printf(logger.INFO, "Starting up the server")
+	go server.HandleSignals()
+	if err := server.Start(); err != nil {
+		logger.Fatalf("Unable to start the server %v", err)
+	}
 }
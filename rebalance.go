@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"time"
+)
+
+const (
+	rebalanceTimeout      = 30 * time.Minute
+	rebalancePollInterval = time.Second
+)
+
+// AddAndRebalance adds nodes to the cluster of ept and rebalances them in
+func AddAndRebalance(ept *CouchbaseNode, nodes []*CouchbaseNode) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+	for _, node := range nodes {
+		logger.Printf(logger.INFO, "Adding node %s to %s", node.Ip, ept.Ip)
+		if err = ept.AddNode(node); err != nil {
+			return err
+		}
+	}
+	if err = rebalance(ept, nil); err != nil {
+		return err
+	}
+	return checkMembership(ept, nodes, MembershipActive)
+}
+
+// RemoveAndRebalance rebalances nodes out of the cluster of ept
+func RemoveAndRebalance(ept *CouchbaseNode, nodes []*CouchbaseNode) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+	for _, node := range nodes {
+		logger.Printf(logger.INFO, "Removing node %s from %s", node.Ip, ept.Ip)
+	}
+	if err = rebalance(ept, nodes); err != nil {
+		return err
+	}
+	return checkMembership(ept, nodes, "")
+}
+
+// FailoverAndRebalance fails nodes over and rebalances the cluster of ept
+// without them
+func FailoverAndRebalance(ept *CouchbaseNode, nodes []*CouchbaseNode) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+	for _, node := range nodes {
+		logger.Printf(logger.INFO, "Failing over node %s on %s", node.Ip, ept.Ip)
+		if err = ept.FailoverNode(node); err != nil {
+			return err
+		}
+	}
+	//Failed over nodes are ejected by the rebalance
+	if err = rebalance(ept, nil); err != nil {
+		return err
+	}
+	return checkMembership(ept, nodes, "")
+}
+
+func rebalance(ept *CouchbaseNode, eject []*CouchbaseNode) (err error) {
+	if err = ept.StartRebalance(eject); err != nil {
+		return err
+	}
+	return WaitForRebalance(ept, rebalanceTimeout)
+}
+
+// WaitForRebalance polls the rebalance progress of ept until it is done
+func WaitForRebalance(ept *CouchbaseNode, timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := ept.RebalanceProgress()
+		if err != nil {
+			return err
+		}
+		if status != "running" {
+			logger.Printf(logger.INFO, "Rebalance on %s finished with status %s", ept.Ip, status)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Rebalance on %s did not finish in %v", ept.Ip, timeout))
+		}
+		time.Sleep(rebalancePollInterval)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"github.com/bsubhashni/go-cbes/proxy"
+	"time"
+)
+
+const (
+	maxWaitTimeForReplication = 10 * time.Second
+)
+
+// RebalanceExecutor runs the AddRb, RemoveRb and FoRb situations. The nodes
+// it adds, removes and fails over while data is written are picked from
+// the inventory by SelectNodes.
+type RebalanceExecutor struct {
+	activeCBNodes      []*CouchbaseNode
+	activeESNodes      []*ESNode
+	esCluster          *ESCluster
+	replicationMapping map[string]string
+	count              int
+	data               *DataSource
+	proxyServer        *proxy.ProxyServer
+	log                *logger.Logger
+	config             *Config
+	situation          Situation
+	topology           Topology
+	eptCB              *CouchbaseNode
+	eptES              *ESNode
+	es                 *ESNode
+	opsBucket          string
+	opsIndex           string
+	referenced         bool
+	result             error
+}
+
+func (ex *RebalanceExecutor) Setup(config *Config) (err error) {
+	ex.config = config
+	ex.situation = config.situation[0]
+	ex.log = logger.Default().WithPrefix(ex.situation.Id)
+
+	if ex.topology, err = SelectNodes(config.CBNodes, ex.situation); err != nil {
+		return err
+	}
+
+	for _, node := range append(ex.topology.Initial, ex.topology.Add...) {
+		ex.log.Printf(logger.INFO, "Starting the couchbase service on node %s", node.Ip)
+		if err = node.Init(); err != nil {
+			ex.log.Printf(logger.ERR, "Error initializing couchbase node %v", err)
+			return err
+		}
+		ex.activeCBNodes = append(ex.activeCBNodes, node)
+	}
+	ex.eptCB = ex.topology.Endpoint
+	if len(ex.eptCB.Services) > 0 {
+		if err = ex.eptCB.SetupServices(); err != nil {
+			ex.log.Printf(logger.INFO, "Services of %s not changed %v", ex.eptCB.Ip, err)
+		}
+	}
+
+	for index, _ := range config.ESNodes {
+		node := &config.ESNodes[index]
+		ex.log.Printf(logger.INFO, "Starting the elastic search service on node %s", node.Ip)
+		if err = node.Init(); err != nil {
+			ex.log.Printf(logger.ERR, "%v", err)
+			return err
+		}
+		ex.activeESNodes = append(ex.activeESNodes, node)
+	}
+	if ex.esCluster, err = NewESCluster(ex.activeESNodes); err != nil {
+		return err
+	}
+	//XDCR replicates to the first node, every other call goes to any node
+	ex.eptES = ex.activeESNodes[0]
+	ex.es = ex.esCluster.Client()
+	if err = config.WaitForHealth(ex.es, ""); err != nil {
+		ex.log.Printf(logger.ERR, "Elastic search is not ready %v", err)
+		return err
+	}
+	if version, err := ex.es.Version(); err == nil {
+		ex.log.Printf(logger.INFO, "Using elastic search %s request formats", version)
+	}
+
+	//Route the replication through the proxy when it is switched on
+	if ex.proxyServer, err = StartProxy(config.Proxy, ex.eptES); err != nil {
+		ex.log.Printf(logger.ERR, "Error starting the proxy %v", err)
+		return err
+	}
+
+	//Build the initial cluster
+	if err = AddAndRebalance(ex.eptCB, ex.topology.Others()); err != nil {
+		ex.log.Printf(logger.ERR, "Error building the initial cluster %v", err)
+		return err
+	}
+
+	//create mapping
+	ex.replicationMapping = make(map[string]string)
+	for index := range config.Replications {
+		bucketname := config.BucketName(index)
+		indexname := config.IndexName(index)
+		//create bucket and index
+		if err = ex.eptCB.CreateBucket(bucketname); err != nil {
+			ex.log.Printf(logger.ERR, "Error creating bucket %v %s", err, ex.eptCB.Ip)
+			return err
+		} else {
+			ex.log.Printf(logger.INFO, "Created bucket %s", bucketname)
+			ex.replicationMapping[bucketname] = indexname
+			time.Sleep(time.Second)
+			if err = ex.eptCB.ConnectToBucket(bucketname); err != nil {
+				ex.log.Printf(logger.ERR, "Error connecting to bucket %s %v", bucketname, err)
+				return err
+			}
+			ex.opsBucket = bucketname
+			ex.opsIndex = indexname
+			ex.data = NewDataSource(config, index, ex.eptCB, ex.log)
+		}
+		if err = SetupIndex(config, ex.es, indexname, ex.log); err != nil {
+			ex.log.Printf(logger.ERR, "%v", err)
+			return err
+		}
+	}
+	SetupExpiry(config, ex.activeCBNodes, ex.opsBucket, ex.log)
+
+	return nil
+}
+
+func (ex *RebalanceExecutor) TearDown() (err error) {
+	StopProxy(ex.proxyServer, ex.eptES)
+
+	var buckets []string
+	for bucketname := range ex.replicationMapping {
+		buckets = append(buckets, bucketname)
+	}
+	if ex.eptCB != nil && len(buckets) > 0 {
+		if err = ex.eptCB.CancelReplicationsOf(buckets); err != nil {
+			ex.log.Printf(logger.ERR, "Error cancelling the replications %v", err)
+			return err
+		}
+	}
+	if ex.referenced {
+		if err = ex.eptCB.DeleteRemoteClusterReference(remoteClusterName); err != nil {
+			ex.log.Printf(logger.ERR, "Error deleting the remote cluster reference %v", err)
+			return err
+		}
+		ex.referenced = false
+	}
+
+	for bucketname, indexname := range ex.replicationMapping {
+		if err = ex.eptCB.DeleteBucket(bucketname); err != nil {
+			ex.log.Printf(logger.ERR, "Error deleting bucket %v", err)
+			return err
+		} else {
+			ex.log.Printf(logger.INFO, "Deleted bucket %s", bucketname)
+		}
+		if err = ex.es.DeleteIndex(indexname); err != nil {
+			ex.log.Printf(logger.ERR, "Error deleting index %v", err)
+			return err
+		} else {
+			ex.log.Printf(logger.INFO, "Deleted index %s", indexname)
+		}
+	}
+	if err = TearDownIndex(ex.config, ex.es, ex.log); err != nil {
+		ex.log.Printf(logger.ERR, "%v", err)
+		return err
+	}
+
+	//Rebalance out every node still in the cluster apart from the endpoint
+	var nodes []*CouchbaseNode
+	members, err := ex.eptCB.ClusterMembers(ex.activeCBNodes)
+	if err != nil {
+		ex.log.Printf(logger.ERR, "Error reading the cluster members %v", err)
+	}
+	for _, node := range members {
+		if node != ex.eptCB {
+			nodes = append(nodes, node)
+		}
+	}
+	if err = RemoveAndRebalance(ex.eptCB, nodes); err != nil {
+		ex.log.Printf(logger.ERR, "Error removing nodes %v", err)
+		return err
+	}
+	return nil
+}
+
+func (ex *RebalanceExecutor) doSituation() {
+	var err error
+	if err = AddAndRebalance(ex.eptCB, ex.topology.Add); err == nil {
+		if err = RemoveAndRebalance(ex.eptCB, ex.topology.Remove); err == nil {
+			err = FailoverAndRebalance(ex.eptCB, ex.topology.Failover)
+		}
+	}
+	if err != nil {
+		ex.log.Printf(logger.ERR, "Error changing the cluster %v", err)
+		ex.result = err
+	}
+	time.Sleep(1 * time.Minute)
+}
+
+func (ex *RebalanceExecutor) Run() time.Duration {
+	//Create Replication between NewBucket and TestIndex
+	esNode := ex.es
+	couchbaseNode := ex.eptCB
+	if err := couchbaseNode.CreateRemoteClusterReference(ex.eptES); err != nil {
+		ex.log.Printf(logger.ERR, "Error creating remote cluster reference %v", err)
+	} else {
+		ex.referenced = true
+	}
+
+	//Start Replication
+	for bucket, index := range ex.replicationMapping {
+		if err := couchbaseNode.CreateReplication(bucket, index); err != nil {
+			ex.log.Printf(logger.ERR, "Error starting the replication %v", err)
+		}
+	}
+
+	//Write while the cluster changes
+	ex.data.Start()
+	ex.doSituation()
+	ex.data.Stop()
+	if err := ex.data.Err(); err != nil && ex.result == nil {
+		ex.result = err
+	}
+
+	opCount := ex.data.Expected()
+	startTime := time.Now()
+
+	//verify the number of docs on the es index
+	replicatedCount, err := esNode.WaitForCount(ex.opsIndex, opCount, ex.data.Uncounted(), maxWaitTimeForReplication)
+	if err != nil {
+		ex.log.Printf(logger.ERR, "Error getting count %v", err)
+		ex.result = err
+	}
+
+	ex.log.Printf(logger.INFO, "Op Count %d replicated Count %d", opCount, replicatedCount)
+	if opCount == replicatedCount {
+		if err := ex.data.VerifyKeys(esNode, ex.opsIndex); err != nil {
+			if ex.result == nil {
+				ex.result = err
+			}
+		} else {
+			ex.log.Printf(logger.INFO, "Passed %s test!!!", ex.situation.Id)
+		}
+		if err := VerifyExpiry(ex.config, ex.data, ex.eptCB, esNode, ex.opsBucket, ex.opsIndex, ex.log); err != nil && ex.result == nil {
+			ex.result = err
+		}
+		if err := VerifyValues(ex.config, ex.eptCB, esNode, ex.opsIndex, ex.log); err != nil && ex.result == nil {
+			ex.result = err
+		}
+	} else if ex.result == nil {
+		ex.result = errors.New(fmt.Sprintf("replicated %d of %d items", replicatedCount, opCount))
+	}
+	ex.log.Printf(logger.INFO, "%d %v", opCount, startTime)
+	return 0
+}
+
+func (ex *RebalanceExecutor) Result() error {
+	return ex.result
+}
@@ -0,0 +1,54 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	envPrefix  = "env:"
+	filePrefix = "file:"
+)
+
+// IsReference reports whether value names an environment variable or a
+// file rather than holding the credential itself
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, envPrefix) || strings.HasPrefix(value, filePrefix)
+}
+
+// Resolve returns the credential value refers to. env:NAME reads the
+// environment variable NAME, file:/path reads the file with the trailing
+// newline removed. Anything else is returned as is.
+func Resolve(value string) (resolved string, err error) {
+	switch {
+	case strings.HasPrefix(value, envPrefix):
+		name := strings.TrimPrefix(value, envPrefix)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New(fmt.Sprintf("environment variable %s is not set", name))
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, filePrefix):
+		name := strings.TrimPrefix(value, filePrefix)
+		bytes, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("unable to read secrets file %s %v", name, err))
+		}
+		return strings.TrimRight(string(bytes), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// ResolvePassword is Resolve for values that must never be logged, the
+// result is registered with the logger to be redacted
+func ResolvePassword(value string) (resolved string, err error) {
+	if resolved, err = Resolve(value); err != nil {
+		return "", err
+	}
+	logger.RegisterSecret(resolved)
+	return resolved, nil
+}
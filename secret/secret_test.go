@@ -0,0 +1,71 @@
+package secret
+
+import (
+	"github.com/bsubhashni/go-cbes/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	if err = ioutil.WriteFile(file, []byte("from a file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CBES_SECRET_TEST", "from the environment")
+	defer os.Unsetenv("CBES_SECRET_TEST")
+	os.Setenv("CBES_SECRET_EMPTY", "")
+	defer os.Unsetenv("CBES_SECRET_EMPTY")
+
+	for _, c := range []struct {
+		value    string
+		resolved string
+		err      string
+	}{
+		{"plain", "plain", ""},
+		{"", "", ""},
+		{"env:CBES_SECRET_TEST", "from the environment", ""},
+		{"env:CBES_SECRET_EMPTY", "", ""},
+		{"env:CBES_SECRET_MISSING", "", "environment variable CBES_SECRET_MISSING is not set"},
+		{"file:" + file, "from a file", ""},
+		{"file:" + filepath.Join(dir, "missing"), "", "unable to read secrets file"},
+		{"ENV:CBES_SECRET_TEST", "ENV:CBES_SECRET_TEST", ""},
+	} {
+		resolved, err := Resolve(c.value)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, expected %q", c.value, err, c.err)
+			}
+			continue
+		}
+		if err != nil || resolved != c.resolved {
+			t.Errorf("%s: resolved to %q %v, expected %q", c.value, resolved, err, c.resolved)
+		}
+		if IsReference(c.value) != (strings.HasPrefix(c.value, "env:") || strings.HasPrefix(c.value, "file:")) {
+			t.Errorf("%s: IsReference %v", c.value, IsReference(c.value))
+		}
+	}
+}
+
+func TestResolvePasswordIsRedacted(t *testing.T) {
+	os.Setenv("CBES_SECRET_PASSWORD", "resolved-password-value")
+	defer os.Unsetenv("CBES_SECRET_PASSWORD")
+
+	resolved, err := ResolvePassword("env:CBES_SECRET_PASSWORD")
+	if err != nil || resolved != "resolved-password-value" {
+		t.Fatalf("resolved to %q %v", resolved, err)
+	}
+	if redacted := logger.Redact("login with resolved-password-value"); redacted != "login with ******" {
+		t.Errorf("resolved password logged as %q", redacted)
+	}
+	if _, err = ResolvePassword("env:CBES_SECRET_UNSET"); err == nil {
+		t.Errorf("unset variable resolved")
+	}
+}
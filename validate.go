@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/workload"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldError is a single problem with the config, Path is the JSON path
+// of the offending field such as cb-nodes[1].password
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError collects every problem found in a config so that they
+// can be fixed in one go
+type ValidationError []FieldError
+
+func (errs ValidationError) Error() string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, e.String())
+	}
+	return fmt.Sprintf("%d problem(s) in config:\n  %s", len(errs), strings.Join(lines, "\n  "))
+}
+
+func (errs *ValidationError) add(path string, format string, v ...interface{}) {
+	*errs = append(*errs, FieldError{path, fmt.Sprintf(format, v...)})
+}
+
+// merge appends the problems of err. Any other error can not be collected
+// and is returned.
+func (errs *ValidationError) merge(err error) error {
+	if err == nil {
+		return nil
+	}
+	if problems, ok := err.(ValidationError); ok {
+		*errs = append(*errs, problems...)
+		return nil
+	}
+	return err
+}
+
+// decodeStrict unmarshals bytes into v and reports every field that v does
+// not know about, prefixed with path
+func decodeStrict(bytes []byte, v interface{}, path string) (err error) {
+	if err = json.Unmarshal(bytes, v); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return ValidationError{{path, fmt.Sprintf("invalid JSON at offset %d: %v", syntaxErr.Offset, err)}}
+		}
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return ValidationError{{joinPath(path, typeErr.Field), fmt.Sprintf("expected %v, got JSON %s", typeErr.Type, typeErr.Value)}}
+		}
+		return err
+	}
+
+	var raw interface{}
+	if err = json.Unmarshal(bytes, &raw); err != nil {
+		return err
+	}
+	var problems ValidationError
+	checkUnknownFields(raw, reflect.TypeOf(v), path, &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	if field == "" {
+		return path
+	}
+	return path + "." + field
+}
+
+// jsonFields returns the tagged fields of a struct type by JSON name.
+// Untagged fields hold runtime state and are not accepted in a config.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || tag == "" || tag == "-" {
+			continue
+		}
+		fields[tag] = field.Type
+	}
+	return fields
+}
+
+func checkUnknownFields(raw interface{}, t reflect.Type, path string, problems *ValidationError) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch value := raw.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		fields := jsonFields(t)
+		var names []string
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fieldType, ok := fields[name]
+			if !ok {
+				problems.add(joinPath(path, name), "unknown field")
+				continue
+			}
+			checkUnknownFields(value[name], fieldType, joinPath(path, name), problems)
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for index, elem := range value {
+			checkUnknownFields(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, index), problems)
+		}
+	}
+}
+
+// ValidateConfig checks a mapped config against its situation without
+// contacting any of the nodes
+func ValidateConfig(config *Config) (err error) {
+	var problems ValidationError
+
+	if len(config.Replications) == 0 {
+		problems.add("replication", "at least one replication is needed")
+	}
+	for index, replication := range config.Replications {
+		path := fmt.Sprintf("replication[%d]", index)
+		switch strings.ToLower(replication.BucketType) {
+		case "couchbase", "memcached":
+		default:
+			problems.add(path+".bucket-type", "must be couchbase or memcached, got %q", replication.BucketType)
+		}
+		if replication.ItemCount <= 0 && replication.Dataset == nil {
+			problems.add(path+".item-count", "must be greater than 0")
+		}
+		if replication.ItemSize < 0 {
+			problems.add(path+".item-size", "must not be negative")
+		}
+		if replication.Document != nil {
+			for _, problem := range replication.Document.Validate() {
+				problems.add(path+".document."+problem.Field, "%s", problem.Message)
+			}
+		}
+		if replication.Dataset != nil {
+			for _, problem := range replication.Dataset.Validate() {
+				problems.add(path+".dataset."+problem.Field, "%s", problem.Message)
+			}
+		}
+	}
+
+	needsSSH := config.Workload != nil && config.Workload.Expiry != nil && config.Workload.Expiry.PagerInterval > 0
+	for _, situation := range config.situation {
+		if situation.AddCount > 0 || situation.RemoveCount > 0 || situation.FailoverCount > 0 {
+			needsSSH = true
+		}
+	}
+
+	if len(config.CBNodes) == 0 {
+		problems.add("cb-nodes", "at least one couchbase node is needed")
+	}
+	for index, node := range config.CBNodes {
+		path := fmt.Sprintf("cb-nodes[%d]", index)
+		required := map[string]string{
+			"ip":       node.Ip,
+			"port":     node.Port,
+			"username": node.AdminUserName,
+			"password": node.AdminPassword,
+		}
+		if needsSSH {
+			required["ssh-username"] = node.SSHUserName
+			if node.SSHKeyFile == "" {
+				required["ssh-password"] = node.SSHPassword
+			}
+		}
+		requireFields(&problems, path, required)
+		validateCouchbaseTLS(&problems, path, node)
+	}
+	validateInventory(&problems, config)
+
+	if len(config.ESNodes) == 0 {
+		problems.add("es-nodes", "at least one elastic search node is needed")
+	}
+	for index, node := range config.ESNodes {
+		path := fmt.Sprintf("es-nodes[%d]", index)
+		requireFields(&problems, path, map[string]string{
+			"ip":             node.Ip,
+			"port":           node.Port,
+			"connector-port": node.ConnectorPort,
+			"username":       node.AdminUserName,
+			"password":       node.AdminPassword,
+		})
+		validateESAuth(&problems, path, node)
+		validateXDCREncryption(&problems, path, node)
+		//The reference points at the plain http proxy instead of the connector
+		if node.XDCREncryption != "" && config.Proxy != nil && config.Proxy.Enabled {
+			problems.add(path+".xdcr-encryption", "can not be used with proxy.enabled")
+		}
+	}
+
+	for _, situation := range config.situation {
+		validateSituation(&problems, config, situation)
+	}
+
+	if config.Workload != nil {
+		for _, problem := range config.Workload.Validate() {
+			problems.add("workload."+problem.Field, "%s", problem.Message)
+		}
+	}
+	for _, action := range config.actions {
+		if action.Mix == nil {
+			continue
+		}
+		options := workload.Options{Mix: action.Mix}
+		for _, problem := range options.Validate() {
+			problems.add(fmt.Sprintf("data-manipulation[%s].%s", action.Id, problem.Field), "%s", problem.Message)
+		}
+	}
+
+	if options := config.Elastic; options != nil {
+		if _, ok := healthRank[options.HealthStatus]; !ok && options.HealthStatus != "" {
+			problems.add("elastic.health-status", "must be %s, %s or %s, got %q",
+				HealthGreen, HealthYellow, HealthRed, options.HealthStatus)
+		}
+		if options.HealthTimeout < 0 {
+			problems.add("elastic.health-timeout", "must not be negative")
+		}
+		if options.Index != nil {
+			options.Index.validate(&problems)
+		}
+	}
+
+	if config.Values != nil {
+		config.Values.validate(&problems)
+	}
+
+	if config.Proxy != nil {
+		config.Proxy.validate(&problems)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func requireFields(problems *ValidationError, path string, fields map[string]string) {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fields[name] == "" {
+			problems.add(path+"."+name, "is required")
+		}
+	}
+}
+
+// validateSituation checks that there are enough nodes for the situation.
+// node-count is the number of couchbase nodes the situation works with and
+// the nodes to add, remove and fail over are picked by SelectNodes.
+func validateSituation(problems *ValidationError, config *Config, situation Situation) {
+	nodes := len(config.CBNodes)
+	if nodes < situation.NodeCount {
+		problems.add("cb-nodes", "situation %s needs %d couchbase nodes (node-count), %d configured",
+			situation.Id, situation.NodeCount, nodes)
+	}
+
+	if _, err := SelectNodes(config.CBNodes, situation); err != nil {
+		problems.add("cb-nodes", "%v", err)
+	}
+
+	if situation.FailoverCount > 0 && situation.ReplicaCount < situation.FailoverCount {
+		problems.add("cluster-situation", "situation %s fails over %d nodes with only %d replicas",
+			situation.Id, situation.FailoverCount, situation.ReplicaCount)
+	}
+}
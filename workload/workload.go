@@ -0,0 +1,521 @@
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bsubhashni/go-cbes/docgen"
+	"github.com/bsubhashni/go-cbes/logger"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Operations of the mix
+const (
+	Set    = "set"
+	Get    = "get"
+	Update = "update"
+	Delete = "delete"
+)
+
+const (
+	DefaultWorkers        = 1
+	DefaultRetries        = 3
+	DefaultRetryBackoff   = 100
+	DefaultMaxErrors      = 100
+	DefaultReportInterval = 10
+)
+
+// Store is what the workload runs against. expiry is in seconds.
+type Store interface {
+	Set(key string, doc interface{}) error
+	SetWithExpiry(key string, expiry int, doc interface{}) error
+	Get(key string) error
+	Delete(key string) error
+}
+
+// Mix holds the percentage of each operation. set creates new documents,
+// update rewrites existing ones with their next version.
+type Mix struct {
+	Set    int `json:"set"`
+	Get    int `json:"get"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+// Expiry makes percent of the sets expire after min-ttl to max-ttl seconds.
+// pager-interval, wait and expect are used when the expirations are
+// verified: the expiry pager is set to run every pager-interval seconds,
+// and after the last document expired the index is given wait seconds to
+// reach the expected state. expect is removed when expired documents have
+// to disappear from the index, kept when they have to stay and report when
+// the outcome is only logged.
+type Expiry struct {
+	Percent       int    `json:"percent"`
+	MinTTL        int    `json:"min-ttl"`
+	MaxTTL        int    `json:"max-ttl"`
+	PagerInterval int    `json:"pager-interval"`
+	Wait          int    `json:"wait"`
+	Expect        string `json:"expect"`
+}
+
+// Expectations of the index for expired documents
+const (
+	ExpectRemoved = "removed"
+	ExpectKept    = "kept"
+	ExpectReport  = "report"
+)
+
+// Options of the workload. ops-per-sec 0 runs as fast as the workers can,
+// retry-backoff is the milliseconds before the first retry and doubles for
+// every further one. An operation that still fails counts against
+// max-errors, the workload stops once more than max-errors failed. retries
+// and max-errors take their defaults when left out, 0 is a valid setting
+// for both.
+type Options struct {
+	Workers        int     `json:"workers"`
+	OpsPerSec      int     `json:"ops-per-sec"`
+	Mix            *Mix    `json:"mix"`
+	Expiry         *Expiry `json:"expiry"`
+	Retries        *int    `json:"retries"`
+	RetryBackoff   int     `json:"retry-backoff"`
+	MaxErrors      *int    `json:"max-errors"`
+	ReportInterval int     `json:"report-interval"`
+}
+
+// Problem is an invalid option, Field is its JSON name
+type Problem struct {
+	Field   string
+	Message string
+}
+
+// Validate returns every invalid option
+func (o *Options) Validate() (problems []Problem) {
+	for _, c := range []struct {
+		field string
+		value int
+	}{
+		{"workers", o.Workers},
+		{"ops-per-sec", o.OpsPerSec},
+		{"retries", valueOr(o.Retries, 0)},
+		{"retry-backoff", o.RetryBackoff},
+		{"max-errors", valueOr(o.MaxErrors, 0)},
+		{"report-interval", o.ReportInterval},
+	} {
+		if c.value < 0 {
+			problems = append(problems, Problem{c.field, "must not be negative"})
+		}
+	}
+	if o.Mix != nil {
+		m := o.Mix
+		if m.Set < 0 || m.Get < 0 || m.Update < 0 || m.Delete < 0 {
+			problems = append(problems, Problem{"mix", "percentages must not be negative"})
+		} else if total := m.Set + m.Get + m.Update + m.Delete; total != 100 {
+			problems = append(problems, Problem{"mix", fmt.Sprintf("percentages must add up to 100, got %d", total)})
+		}
+	}
+	if x := o.Expiry; x != nil {
+		if x.Percent < 0 || x.Percent > 100 {
+			problems = append(problems, Problem{"expiry.percent", "must be between 0 and 100"})
+		}
+		if x.MinTTL <= 0 {
+			problems = append(problems, Problem{"expiry.min-ttl", "must be greater than 0"})
+		}
+		if x.MaxTTL < x.MinTTL {
+			problems = append(problems, Problem{"expiry.max-ttl", "must not be smaller than min-ttl"})
+		}
+		if x.PagerInterval < 0 || x.Wait < 0 {
+			problems = append(problems, Problem{"expiry", "pager-interval and wait must not be negative"})
+		}
+		switch x.Expect {
+		case "", ExpectRemoved, ExpectKept, ExpectReport:
+		default:
+			problems = append(problems, Problem{"expiry.expect", fmt.Sprintf("must be %s, %s or %s, got %q",
+				ExpectRemoved, ExpectKept, ExpectReport, x.Expect)})
+		}
+	}
+	return problems
+}
+
+func valueOr(value *int, fallback int) int {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+// Stats counts the operations done so far
+type Stats struct {
+	Sets    int
+	Gets    int
+	Updates int
+	Deletes int
+	Errors  int
+	Retries int
+}
+
+func (s Stats) Total() int {
+	return s.Sets + s.Gets + s.Updates + s.Deletes
+}
+
+// Engine runs a workload of generated documents with a number of workers
+// at a target rate. It keeps track of which documents exist and in which
+// version, so the expected state can be regenerated for verification.
+type Engine struct {
+	store     Store
+	generator *docgen.Generator
+	options   Options
+	retries   int
+	maxErrors int
+	log       *logger.Logger
+
+	mu       sync.Mutex
+	stats    Stats
+	next     int
+	issued   int
+	limit    int
+	versions map[int]int
+	deleted  map[int]bool
+	unknown  map[int]bool
+	busy     map[int]bool
+	expires  map[int]time.Time
+	err      error
+
+	rateMu   sync.Mutex
+	nextSlot time.Time
+
+	stop     chan bool
+	stopOnce *sync.Once
+	wg       sync.WaitGroup
+}
+
+func New(store Store, generator *docgen.Generator, options Options, log *logger.Logger) *Engine {
+	if options.Workers == 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.Mix == nil {
+		options.Mix = &Mix{Set: 100}
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = DefaultRetryBackoff
+	}
+	if options.ReportInterval == 0 {
+		options.ReportInterval = DefaultReportInterval
+	}
+	if log == nil {
+		log = logger.Default().WithPrefix("workload")
+	}
+	return &Engine{
+		store:     store,
+		generator: generator,
+		options:   options,
+		retries:   valueOr(options.Retries, DefaultRetries),
+		maxErrors: valueOr(options.MaxErrors, DefaultMaxErrors),
+		log:       log,
+		versions:  make(map[int]int),
+		deleted:   make(map[int]bool),
+		unknown:   make(map[int]bool),
+		busy:      make(map[int]bool),
+		expires:   make(map[int]time.Time),
+	}
+}
+
+// Start runs the workers until Stop is called
+func (e *Engine) Start() {
+	e.start(0)
+}
+
+// Run does count operations and returns once they are done
+func (e *Engine) Run(count int) Stats {
+	e.start(count)
+	e.wg.Wait()
+	return e.Stop()
+}
+
+func (e *Engine) start(limit int) {
+	e.limit = limit
+	e.stop = make(chan bool)
+	e.stopOnce = &sync.Once{}
+	e.nextSlot = time.Now()
+	for i := 0; i < e.options.Workers; i++ {
+		e.wg.Add(1)
+		go e.worker(int64(i))
+	}
+	go e.report()
+}
+
+// Stop stops the workers, waits for the operations in flight and returns
+// the final stats
+func (e *Engine) Stop() Stats {
+	e.halt()
+	e.wg.Wait()
+
+	stats := e.Stats()
+	e.log.Printf(logger.INFO, "Workload done: %d ops (set %d, get %d, update %d, delete %d), %d errors, %d retries",
+		stats.Total(), stats.Sets, stats.Gets, stats.Updates, stats.Deletes, stats.Errors, stats.Retries)
+	return stats
+}
+
+// halt tells the workers and the reporter to stop, however often it is
+// called and also when the workload never started
+func (e *Engine) halt() {
+	if e.stopOnce == nil {
+		return
+	}
+	e.stopOnce.Do(func() { close(e.stop) })
+}
+
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
+
+// Err is set when the workload stopped because of too many errors
+func (e *Engine) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Expected returns the number of documents written and not deleted.
+// Documents written with an expiry are left out whether they expired or
+// not, the expiry pager may remove them at any time and what becomes of
+// them in the index is up to the expiry expectation. Documents whose last
+// operation failed are left out as well, they may or may not exist.
+func (e *Engine) Expected() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	expected := e.next - len(e.deleted) - len(e.unknown)
+	for n := range e.expires {
+		if !e.deleted[n] {
+			expected--
+		}
+	}
+	return expected
+}
+
+// Expiring returns the keys of the documents written with an expiry and
+// the time the last of them expires
+func (e *Engine) Expiring() (keys []string, last time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for n, at := range e.expires {
+		keys = append(keys, e.generator.Key(n))
+		if at.After(last) {
+			last = at
+		}
+	}
+	return keys, last
+}
+
+// Unknown returns the keys of the documents whose last operation failed
+func (e *Engine) Unknown() (keys []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for n := range e.unknown {
+		keys = append(keys, e.generator.Key(n))
+	}
+	return keys
+}
+
+// Each calls fn with the key and version of every document that exists
+func (e *Engine) Each(fn func(n int, version int)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	for n := 0; n < e.next; n++ {
+		if at, expiring := e.expires[n]; e.deleted[n] || e.unknown[n] || (expiring && !at.After(now)) {
+			continue
+		}
+		fn(n, e.versions[n])
+	}
+}
+
+func (e *Engine) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks until the next slot of the target rate
+func (e *Engine) wait() {
+	if e.options.OpsPerSec == 0 {
+		return
+	}
+	interval := time.Second / time.Duration(e.options.OpsPerSec)
+
+	e.rateMu.Lock()
+	now := time.Now()
+	if e.nextSlot.Before(now) {
+		e.nextSlot = now
+	}
+	slot := e.nextSlot
+	e.nextSlot = slot.Add(interval)
+	e.rateMu.Unlock()
+
+	time.Sleep(slot.Sub(now))
+}
+
+type op struct {
+	kind    string
+	n       int
+	version int
+	expiry  int
+}
+
+// nextOp picks the next operation and reserves its document. Operations on
+// existing documents fall back to a set when there is none to pick.
+func (e *Engine) nextOp(r *rand.Rand) (o op, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.limit > 0 && e.issued >= e.limit {
+		return o, false
+	}
+	e.issued++
+
+	mix := e.options.Mix
+	pick := r.Intn(100)
+	switch {
+	case pick < mix.Set:
+		o.kind = Set
+	case pick < mix.Set+mix.Get:
+		o.kind = Get
+	case pick < mix.Set+mix.Get+mix.Update:
+		o.kind = Update
+	default:
+		o.kind = Delete
+	}
+
+	if o.kind != Set {
+		o.n = -1
+		for attempt := 0; attempt < 8 && e.next > 0; attempt++ {
+			n := r.Intn(e.next)
+			if _, expiring := e.expires[n]; !e.deleted[n] && !e.unknown[n] && !e.busy[n] && !expiring {
+				o.n = n
+				break
+			}
+		}
+		if o.n < 0 {
+			o.kind = Set
+		}
+	}
+	if o.kind == Set {
+		o.n = e.next
+		e.next++
+		if x := e.options.Expiry; x != nil && r.Intn(100) < x.Percent {
+			o.expiry = x.MinTTL + r.Intn(x.MaxTTL-x.MinTTL+1)
+		}
+	}
+	if o.kind == Update {
+		o.version = e.versions[o.n] + 1
+	}
+	e.busy[o.n] = true
+	return o, true
+}
+
+// done records the outcome of an operation. A failed write leaves its
+// document unknown, it may have been written or not.
+func (e *Engine) done(o op, retries int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.busy, o.n)
+	e.stats.Retries += retries
+	if err != nil {
+		e.stats.Errors++
+		if o.kind != Get {
+			e.unknown[o.n] = true
+		}
+		if e.stats.Errors > e.maxErrors && e.err == nil {
+			e.err = errors.New(fmt.Sprintf("%d operations failed, last %s of %s: %v",
+				e.stats.Errors, o.kind, e.generator.Key(o.n), err))
+			e.halt()
+		}
+		return
+	}
+
+	switch o.kind {
+	case Set:
+		e.stats.Sets++
+		if o.expiry > 0 {
+			e.expires[o.n] = time.Now().Add(time.Duration(o.expiry) * time.Second)
+		}
+	case Get:
+		e.stats.Gets++
+	case Update:
+		e.stats.Updates++
+		e.versions[o.n] = o.version
+	case Delete:
+		e.stats.Deletes++
+		e.deleted[o.n] = true
+		delete(e.versions, o.n)
+	}
+}
+
+func (e *Engine) do(o op) (err error) {
+	key := e.generator.Key(o.n)
+	switch o.kind {
+	case Set:
+		if o.expiry > 0 {
+			return e.store.SetWithExpiry(key, o.expiry, e.generator.DocumentVersion(o.n, o.version))
+		}
+		return e.store.Set(key, e.generator.DocumentVersion(o.n, o.version))
+	case Update:
+		return e.store.Set(key, e.generator.DocumentVersion(o.n, o.version))
+	case Get:
+		return e.store.Get(key)
+	default:
+		return e.store.Delete(key)
+	}
+}
+
+func (e *Engine) worker(id int64) {
+	defer e.wg.Done()
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + id))
+
+	for !e.stopped() {
+		e.wait()
+		o, ok := e.nextOp(r)
+		if !ok {
+			return
+		}
+
+		backoff := time.Duration(e.options.RetryBackoff) * time.Millisecond
+		retries := 0
+		err := e.do(o)
+		for err != nil && retries < e.retries && !e.stopped() {
+			e.log.Printf(logger.DEBUG, "Retrying %s of %s in %v: %v", o.kind, e.generator.Key(o.n), backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			retries++
+			err = e.do(o)
+		}
+		e.done(o, retries, err)
+	}
+}
+
+// report logs the throughput every report interval until the workload stops
+func (e *Engine) report() {
+	interval := time.Duration(e.options.ReportInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := e.Stats()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			stats := e.Stats()
+			rate := float64(stats.Total()-last.Total()) / interval.Seconds()
+			e.log.Printf(logger.INFO, "%.0f ops/s, %d ops (set %d, get %d, update %d, delete %d), %d errors, %d retries",
+				rate, stats.Total(), stats.Sets, stats.Gets, stats.Updates, stats.Deletes, stats.Errors, stats.Retries)
+			last = stats
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package workload
+
+import (
+	"errors"
+	"github.com/bsubhashni/go-cbes/docgen"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore keeps the documents in memory. The first fail attempts on
+// every key fail.
+type fakeStore struct {
+	mu       sync.Mutex
+	docs     map[string]bool
+	attempts map[string]int
+	fail     int
+}
+
+func newFakeStore(fail int) *fakeStore {
+	return &fakeStore{docs: make(map[string]bool), attempts: make(map[string]int), fail: fail}
+}
+
+func (s *fakeStore) attempt(key string) error {
+	s.attempts[key]++
+	if s.attempts[key] <= s.fail {
+		return errors.New("temporary failure")
+	}
+	return nil
+}
+
+func (s *fakeStore) Set(key string, doc interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.attempt(key); err != nil {
+		return err
+	}
+	s.docs[key] = true
+	return nil
+}
+
+func (s *fakeStore) SetWithExpiry(key string, expiry int, doc interface{}) error {
+	return s.Set(key, doc)
+}
+
+func (s *fakeStore) Get(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.attempt(key); err != nil {
+		return err
+	}
+	if !s.docs[key] {
+		return errors.New("not found " + key)
+	}
+	return nil
+}
+
+func (s *fakeStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.attempt(key); err != nil {
+		return err
+	}
+	if !s.docs[key] {
+		return errors.New("not found " + key)
+	}
+	delete(s.docs, key)
+	return nil
+}
+
+func (s *fakeStore) keys() (keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func TestMix(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		mix   Mix
+		check func(stats Stats) bool
+	}{
+		{"sets only", Mix{Set: 100}, func(s Stats) bool { return s.Sets == 400 }},
+		{"gets fall back to a set without documents", Mix{Get: 100},
+			func(s Stats) bool { return s.Sets == 1 && s.Gets == 399 }},
+		{"sets and deletes", Mix{Set: 50, Delete: 50},
+			func(s Stats) bool { return s.Sets+s.Deletes == 400 && s.Sets >= s.Deletes && s.Deletes > 100 }},
+		{"all operations", Mix{Set: 40, Get: 20, Update: 20, Delete: 20},
+			func(s Stats) bool { return s.Gets > 0 && s.Updates > 0 && s.Deletes > 0 && s.Total() == 400 }},
+	} {
+		store := newFakeStore(0)
+		mix := c.mix
+		engine := New(store, docgen.New(docgen.Options{}), Options{Workers: 4, Mix: &mix}, nil)
+		stats := engine.Run(400)
+		if stats.Errors != 0 || !c.check(stats) {
+			t.Errorf("%s: unexpected stats %+v", c.name, stats)
+		}
+
+		var existing []string
+		engine.Each(func(n int, version int) {
+			existing = append(existing, engine.generator.Key(n))
+		})
+		sort.Strings(existing)
+		if keys := store.keys(); engine.Expected() != len(keys) || strings.Join(existing, ",") != strings.Join(keys, ",") {
+			t.Errorf("%s: expected %d documents %v, store holds %v", c.name, engine.Expected(), existing, keys)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	engine := New(newFakeStore(0), docgen.New(docgen.Options{}), Options{Workers: 4, OpsPerSec: 200}, nil)
+	start := time.Now()
+	stats := engine.Run(41)
+	//41 operations at 200 per second are 40 intervals of 5ms apart
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("41 operations at 200 ops/s took %v", elapsed)
+	}
+	if stats.Total() != 41 {
+		t.Errorf("%d operations done, expected 41", stats.Total())
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		fail      int
+		retries   *int
+		maxErrors *int
+		stats     Stats
+		expected  int
+		err       string
+	}{
+		{"no failures", 0, nil, nil, Stats{Sets: 20}, 20, ""},
+		{"retried until written", 2, intPtr(2), nil, Stats{Sets: 20, Retries: 40}, 20, ""},
+		{"default retries", 3, nil, nil, Stats{Sets: 20, Retries: 60}, 20, ""},
+		{"failed after retries", 3, intPtr(2), nil, Stats{Errors: 20, Retries: 40}, 0, ""},
+		{"never retried", 1, intPtr(0), nil, Stats{Errors: 20}, 0, ""},
+		{"stops after max errors", 1, intPtr(0), intPtr(0), Stats{Errors: 1}, 0, "1 operations failed, last set of"},
+	} {
+		engine := New(newFakeStore(c.fail), docgen.New(docgen.Options{}),
+			Options{Retries: c.retries, RetryBackoff: 1, MaxErrors: c.maxErrors}, nil)
+		stats := engine.Run(20)
+		if stats != c.stats {
+			t.Errorf("%s: stats %+v, expected %+v", c.name, stats, c.stats)
+		}
+		if expected := engine.Expected(); expected != c.expected {
+			t.Errorf("%s: %d documents expected, expected %d", c.name, expected, c.expected)
+		}
+		if unknown := len(engine.Unknown()); unknown != c.stats.Errors {
+			t.Errorf("%s: %d unknown documents, expected %d", c.name, unknown, c.stats.Errors)
+		}
+		err := engine.Err()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error %v, expected %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	engine := New(newFakeStore(0), docgen.New(docgen.Options{}), Options{}, nil)
+	if stats := engine.Stop(); stats.Total() != 0 {
+		t.Errorf("stats %+v without starting", stats)
+	}
+	engine.Stop()
+}